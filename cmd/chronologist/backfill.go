@@ -0,0 +1,151 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// backfillProgressPeriod is how often backfill reports its progress.
+const backfillProgressPeriod = time.Second * 10
+
+// runBackfill registers release events of all releases deployed within the
+// period given in args in the chronicle, regardless of ReleaseRevisionMaxAge,
+// e.g. to import the history of deploys into a new Grafana.
+func runBackfill(args []string) error {
+	fs, conf, err := newFlagSet("backfill", "")
+	if err != nil {
+		return err
+	}
+	since := fs.String("since", "90d", "backfill releases deployed within this period, e.g. 90d or 12h")
+	rate := fs.Float64("rate", 5, "maximum number of release events registered per second, 0 means no limit")
+	log, err := parseFlags(fs, conf, args)
+	if err != nil {
+		return err
+	}
+
+	period, err := parsePeriod(*since)
+	if err != nil {
+		return errors.Wrap(err, "parse since")
+	}
+
+	conf.disableBackground()
+
+	lister, err := newLister(*conf)
+	if err != nil {
+		return err
+	}
+
+	chronicle, runners, err := newChronicle(*conf, lister, log)
+	if err != nil {
+		return errors.Wrap(err, "create chronicle")
+	}
+
+	// Runners flush pending release events when stopped, so they are
+	// stopped only after backfill is done.
+	stop := startRunners(runners)
+	defer stop()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	return backfill(ctx, lister, chronicle, log, time.Now().Add(-period), *rate)
+}
+
+// backfill registers release events listed by releases that happened after
+// from in the chronicle, oldest first, at most rate per second.
+func backfill(ctx context.Context, releases chronologist.Lister, chronicle chronologist.Chronicle, log *zap.Logger, from time.Time, rate float64) error {
+	events, err := listReleaseEvents(ctx, releases, log)
+	if err != nil {
+		return errors.Wrap(err, "list releases")
+	}
+
+	var selected []chronologist.ReleaseEvent
+	for _, re := range events {
+		if !re.Time.Before(from) {
+			selected = append(selected, re)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].Time.Before(selected[j].Time)
+	})
+
+	log.Sugar().Infof("Backfilling %d release events deployed since %s", len(selected), from.UTC().Format(time.RFC3339))
+
+	var tick <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	failed := 0
+	reported := time.Now()
+	for i, re := range selected {
+		if i > 0 && tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return errors.Wrapf(err, "backfilled %d of %d release events", i, len(selected))
+		}
+
+		rctx := zaplog.WithFields(ctx, zap.String("release", re.Name), zap.String("revision", re.Revision))
+		if err := chronicle.Register(rctx, re); err != nil {
+			failed++
+			log.Sugar().Errorf("Failed to backfill release %s revision %s: %s", re.Name, re.Revision, err)
+		}
+
+		if time.Since(reported) >= backfillProgressPeriod {
+			log.Sugar().Infof("Backfilled %d/%d release events, %d failed", i+1, len(selected), failed)
+			reported = time.Now()
+		}
+	}
+
+	log.Sugar().Infof("Backfilled %d release events, %d failed", len(selected), failed)
+	if failed > 0 {
+		return fmt.Errorf("failed to backfill %d of %d release events", failed, len(selected))
+	}
+	return nil
+}
+
+// parsePeriod parses a duration that, in addition to units supported by
+// time.ParseDuration, can be in days, e.g. "90d".
+func parsePeriod(s string) (time.Duration, error) {
+	if !strings.HasSuffix(s, "d") {
+		return time.ParseDuration(s)
+	}
+
+	days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid period %q", s)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+)
+
+type stubLister struct {
+	events []chronologist.ReleaseEvent
+	err    error
+}
+
+func (l stubLister) ListReleaseEvents(ctx context.Context) ([]chronologist.ReleaseEvent, error) {
+	return l.events, l.err
+}
+
+type stubChronicle struct {
+	registered []string
+	failing    map[string]bool
+}
+
+func (c *stubChronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	if c.failing[re.Name+"/"+re.Revision] {
+		return errors.New("grafana is down")
+	}
+	c.registered = append(c.registered, re.Name+"/"+re.Revision)
+	return nil
+}
+
+func (c *stubChronicle) Unregister(ctx context.Context, name, revision string) error {
+	return nil
+}
+
+func TestBackfill(t *testing.T) {
+	from := time.Date(2019, 01, 01, 0, 0, 0, 0, time.UTC)
+	events := []chronologist.ReleaseEvent{
+		{Name: "foo", Revision: "2", Time: from.Add(time.Hour * 2)},
+		{Name: "bar", Revision: "1", Time: from.Add(-time.Hour)},
+		{Name: "foo", Revision: "1", Time: from},
+		{Name: "bar", Revision: "2", Time: from.Add(time.Hour)},
+	}
+
+	testCases := []struct {
+		name       string
+		lister     stubLister
+		failing    map[string]bool
+		rate       float64
+		registered []string
+		minElapsed time.Duration
+		err        string
+	}{
+		{
+			name:       "registers events since from, oldest first",
+			lister:     stubLister{events: events},
+			registered: []string{"foo/1", "bar/2", "foo/2"},
+		},
+		{
+			name:       "rate limits registrations",
+			lister:     stubLister{events: events},
+			rate:       20,
+			registered: []string{"foo/1", "bar/2", "foo/2"},
+			minElapsed: time.Millisecond * 100,
+		},
+		{
+			name:       "counts failures without stopping",
+			lister:     stubLister{events: events},
+			failing:    map[string]bool{"foo/1": true, "foo/2": true},
+			registered: []string{"bar/2"},
+			err:        "failed to backfill 2 of 3 release events",
+		},
+		{
+			name:       "skips releases that cannot be read",
+			lister:     stubLister{events: events[:1], err: errors.New("decode bar")},
+			registered: []string{"foo/2"},
+		},
+		{
+			name:   "list error",
+			lister: stubLister{err: errors.New("forbidden")},
+			err:    "list releases: forbidden",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chronicle := &stubChronicle{failing: tc.failing}
+
+			start := time.Now()
+			err := backfill(context.Background(), tc.lister, chronicle, zap.NewNop(), from, tc.rate)
+			elapsed := time.Since(start)
+
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+			assert.Equal(t, tc.registered, chronicle.registered)
+			assert.True(t, elapsed >= tc.minElapsed, "backfill took %s, expected at least %s", elapsed, tc.minElapsed)
+		})
+	}
+}
+
+// Tests that backfill stops when the context is canceled.
+func TestBackfill_canceled(t *testing.T) {
+	from := time.Date(2019, 01, 01, 0, 0, 0, 0, time.UTC)
+	lister := stubLister{events: []chronologist.ReleaseEvent{
+		{Name: "foo", Revision: "1", Time: from},
+		{Name: "foo", Revision: "2", Time: from.Add(time.Hour)},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	chronicle := &stubChronicle{}
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		cancel()
+	}()
+
+	// One release event per hour, so the second one is never registered.
+	err := backfill(ctx, lister, chronicle, zap.NewNop(), from, 1.0/3600)
+	assert.EqualError(t, err, "backfilled 1 of 2 release events: context canceled")
+	assert.Equal(t, []string{"foo/1"}, chronicle.registered)
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/alertmanager"
+	"github.com/hypnoglow/chronologist/internal/auditlog"
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/datadog"
+	"github.com/hypnoglow/chronologist/internal/elasticsearch"
+	"github.com/hypnoglow/chronologist/internal/github"
+	"github.com/hypnoglow/chronologist/internal/grafana"
+	"github.com/hypnoglow/chronologist/internal/graphite"
+	"github.com/hypnoglow/chronologist/internal/influxdb"
+	"github.com/hypnoglow/chronologist/internal/loki"
+	"github.com/hypnoglow/chronologist/internal/pagerduty"
+)
+
+// runner is implemented by chronicles that need a background loop,
+// e.g. to push batches of release events.
+type runner interface {
+	Run(stopCh <-chan struct{})
+}
+
+// runnerFunc is an adapter to allow the use of ordinary functions as runners.
+type runnerFunc func(stopCh <-chan struct{})
+
+// Run calls f(stopCh).
+func (f runnerFunc) Run(stopCh <-chan struct{}) {
+	f(stopCh)
+}
+
+// newChronicle assembles a chronicle from all sinks enabled in the config.
+// It also returns the sinks that must be run in background, including
+// garbage collectors of Grafana annotations that check releases listed
+// by releases.
+func newChronicle(conf Config, releases chronologist.Lister, log *zap.Logger) (chronologist.Chronicle, []runner, error) {
+	instances, runners, err := newGrafanaInstances(conf, log)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chronicle, sinkRunners, err := newChronicleWithGrafana(conf, instances, releases, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chronicle, append(runners, sinkRunners...), nil
+}
+
+// newChronicleWithGrafana is like newChronicle, but uses already created
+// Grafana instances.
+func newChronicleWithGrafana(conf Config, instances []grafana.Instance, releases chronologist.Lister, log *zap.Logger) (chronologist.Chronicle, []runner, error) {
+	if conf.GrafanaGCInterval > 0 {
+		if err := conf.checkGrafanaGC(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var runners []runner
+	var chronicles chronologist.MultiChronicle
+	dryRuns := make(map[string]*grafana.DryRun)
+	for _, g := range instances {
+		var annotator grafana.Annotator = g.Client
+		if conf.DryRun {
+			dr := grafana.NewDryRun(g.Client, g.Log)
+			dryRuns[g.Name] = dr
+			annotator = dr
+		}
+
+		chronicles = append(chronicles, grafana.NewChronicle(annotator, g.Log, g.Options))
+		if conf.GrafanaGCInterval > 0 {
+			runners = append(runners, newGrafanaCollector(conf, annotator, g.Options.Target, releases, g.Log))
+		}
+	}
+
+	if conf.DryRun {
+		log.Warn("Dry run mode: changes in Grafana are only recorded, other sinks are disabled")
+		if conf.DebugAddr != "" {
+			runners = append(runners, newHTTPServer("debug endpoints", conf.DebugAddr, dryRunHandler(dryRuns), log))
+		}
+		return chronicles, runners, nil
+	}
+
+	if conf.LokiAddr != "" {
+		lc := loki.NewChronicle(
+			loki.NewClient(conf.LokiAddr, conf.LokiTenantID),
+			log.Named("loki"),
+			loki.Options{
+				Cluster:   conf.ClusterName,
+				BatchSize: conf.LokiBatchSize,
+				BatchWait: conf.LokiBatchWait,
+			},
+		)
+		chronicles = append(chronicles, lc)
+		runners = append(runners, lc)
+	}
+
+	if conf.ElasticsearchAddr != "" {
+		ec := elasticsearch.NewChronicle(
+			elasticsearch.NewClient(conf.ElasticsearchAddr, conf.ElasticsearchUsername, conf.ElasticsearchPassword),
+			log.Named("elasticsearch"),
+			elasticsearch.Options{
+				Cluster:         conf.ClusterName,
+				IndexPrefix:     conf.ElasticsearchIndexPrefix,
+				IndexDateLayout: conf.ElasticsearchIndexDateLayout,
+				SoftDelete:      conf.ElasticsearchSoftDelete,
+				BatchSize:       conf.ElasticsearchBatchSize,
+				BatchWait:       conf.ElasticsearchBatchWait,
+			},
+		)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+		if err := ec.Setup(ctx); err != nil {
+			return nil, nil, errors.Wrap(err, "set up elasticsearch")
+		}
+
+		chronicles = append(chronicles, ec)
+		runners = append(runners, ec)
+	}
+
+	if conf.InfluxDBAddr != "" {
+		ic := influxdb.NewClient(conf.InfluxDBAddr, influxdb.ClientOptions{
+			Database: conf.InfluxDBDatabase,
+			Username: conf.InfluxDBUsername,
+			Password: conf.InfluxDBPassword,
+			Org:      conf.InfluxDBOrg,
+			Bucket:   conf.InfluxDBBucket,
+			Token:    conf.InfluxDBToken,
+		})
+		chronicles = append(chronicles, influxdb.NewChronicle(ic, log.Named("influxdb"), conf.ClusterName))
+	}
+
+	if conf.GraphiteAddr != "" {
+		gc := graphite.NewClient(conf.GraphiteAddr, conf.GraphiteUsername, conf.GraphitePassword)
+		chronicles = append(chronicles, graphite.NewChronicle(gc, log.Named("graphite"), conf.ClusterName))
+	}
+
+	if conf.DatadogAPIKey != "" {
+		dc := datadog.NewClient(conf.DatadogAddr, conf.DatadogAPIKey, conf.DatadogAppKey)
+		if conf.DatadogAppKey == "" {
+			log.Warn("Datadog application key is not set, release events will be posted to Datadog again on every restart")
+		}
+		chronicles = append(chronicles, datadog.NewChronicle(dc, log.Named("datadog"), datadog.Options{
+			Cluster:     conf.ClusterName,
+			QueryEvents: conf.DatadogAppKey != "",
+		}))
+	}
+
+	routingKeys := pagerduty.RoutingKeys{
+		ByRelease:   conf.PagerDutyRoutingKeysByRelease,
+		ByLabel:     conf.PagerDutyRoutingKeysByLabel,
+		ByNamespace: conf.PagerDutyRoutingKeysByNamespace,
+		Default:     conf.PagerDutyRoutingKey,
+	}
+	if routingKeys.Default != "" || len(routingKeys.ByNamespace) > 0 || len(routingKeys.ByLabel) > 0 || len(routingKeys.ByRelease) > 0 {
+		opts := pagerduty.Options{
+			Cluster:     conf.ClusterName,
+			RoutingKeys: routingKeys,
+			Since:       time.Now(),
+		}
+		link := conf.PagerDutyLinkTemplate
+		if link == "" && conf.GrafanaAddr != "" {
+			link = "{{ grafanaDashboardURL . }}"
+		}
+		if link != "" {
+			tmpl, err := template.New("link").Funcs(template.FuncMap{
+				"grafanaDashboardURL": func(re chronologist.ReleaseEvent) string {
+					return grafana.DashboardURL(conf.GrafanaAddr, conf.GrafanaRoutes, re)
+				},
+			}).Parse(link)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "parse pagerduty link template")
+			}
+			opts.Link = tmpl
+		}
+
+		pc := pagerduty.NewClient(conf.PagerDutyAddr)
+		chronicles = append(chronicles, pagerduty.NewChronicle(pc, log.Named("pagerduty"), opts))
+	}
+
+	if conf.GitHubToken != "" {
+		gc := github.NewClient(conf.GitHubAddr, conf.GitHubToken)
+		chronicles = append(chronicles, github.NewChronicle(gc, log.Named("github"), github.Options{
+			Cluster:      conf.ClusterName,
+			Repositories: conf.GitHubRepositories,
+		}))
+	}
+
+	if conf.AlertmanagerAddr != "" {
+		ac := alertmanager.NewClient(conf.AlertmanagerAddr)
+		chronicles = append(chronicles, alertmanager.NewChronicle(ac, log.Named("alertmanager"), alertmanager.Options{
+			Cluster:        conf.ClusterName,
+			Duration:       conf.AlertmanagerSilenceDuration,
+			NamespaceLabel: conf.AlertmanagerNamespaceLabel,
+			ReleaseLabel:   conf.AlertmanagerReleaseLabel,
+			Matchers:       conf.AlertmanagerMatchers,
+		}))
+	}
+
+	if conf.AuditLogPath != "" {
+		f, err := auditlog.OpenRotatingFile(conf.AuditLogPath, auditlog.FileOptions{
+			MaxSize:     conf.AuditLogMaxSize,
+			MaxSegments: conf.AuditLogMaxSegments,
+			Compress:    conf.AuditLogCompress,
+		})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "open audit log")
+		}
+
+		chronicles = append(chronicles, auditlog.NewChronicle(f, log.Named("auditlog"), conf.ClusterName))
+		runners = append(runners, runnerFunc(func(stopCh <-chan struct{}) {
+			<-stopCh
+			if err := f.Close(); err != nil {
+				log.Sugar().Errorf("Failed to close audit log: %s", err)
+			}
+		}))
+	}
+
+	return chronicles, runners, nil
+}
+
+// newGrafanaInstances creates clients of the primary Grafana and of the
+// Grafana targets enabled in the config. It also returns the credentials
+// that must be run in background.
+func newGrafanaInstances(conf Config, log *zap.Logger) ([]grafana.Instance, []runner, error) {
+	instances, grafanaRunners, err := grafana.NewInstances(conf.GrafanaSettings(), log)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	runners := make([]runner, 0, len(grafanaRunners))
+	for _, r := range grafanaRunners {
+		runners = append(runners, r)
+	}
+	return instances, runners, nil
+}
+
+// selectGrafanaInstances returns the instance with the name, or all of them
+// if the name is empty.
+func selectGrafanaInstances(instances []grafana.Instance, name string) ([]grafana.Instance, error) {
+	if name == "" {
+		return instances, nil
+	}
+	for _, g := range instances {
+		if g.Name == name {
+			return []grafana.Instance{g}, nil
+		}
+	}
+	return nil, errors.Errorf("no grafana named %q", name)
+}
+
+// startRunners runs the runners in background. The returned function stops
+// them and waits for them to finish, e.g. to flush pending release events.
+func startRunners(runners []runner) (stop func()) {
+	stopCh := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, r := range runners {
+		wg.Add(1)
+		go func(r runner) {
+			defer wg.Done()
+			r.Run(stopCh)
+		}(r)
+	}
+
+	return func() {
+		close(stopCh)
+		wg.Wait()
+	}
+}
+
+// newGrafanaCollector returns a garbage collector of annotations of the
+// Grafana target (or the primary Grafana, if empty) configured by the config.
+func newGrafanaCollector(conf Config, client grafana.Annotator, target string, releases chronologist.Lister, log *zap.Logger) *grafana.Collector {
+	return grafana.NewCollector(client, releases, log.Named("gc"), grafana.CollectorOptions{
+		Cluster:  conf.ClusterName,
+		Target:   target,
+		Policy:   conf.GrafanaGCPolicy,
+		Interval: conf.GrafanaGCInterval,
+		DryRun:   conf.GrafanaGCDryRun,
+	})
+}
@@ -17,12 +17,17 @@ limitations under the License.
 package main
 
 import (
+	"encoding"
+	"flag"
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
 
+	"github.com/hypnoglow/chronologist/internal/grafana"
 	"github.com/hypnoglow/chronologist/internal/zaplog"
 )
 
@@ -33,8 +38,130 @@ type Config struct {
 	// KubeConfigPath is an absolute path to the kubeconfig file.
 	KubeConfigPath string `envconfig:"KUBECONFIG" required:"false"`
 
-	GrafanaAddr   string `envconfig:"GRAFANA_ADDR" required:"true"`
-	GrafanaAPIKey string `envconfig:"GRAFANA_API_KEY" required:"true"`
+	// ClusterName identifies the cluster Chronologist runs in. Sinks that
+	// support it attach this name to release events.
+	ClusterName string `envconfig:"CLUSTER_NAME" required:"false"`
+
+	// GrafanaAddr configures the primary Grafana that annotates all release
+	// events. It can be omitted when GrafanaTargets are set.
+	GrafanaAddr  string `envconfig:"GRAFANA_ADDR" required:"false"`
+	GrafanaOrgID int64  `envconfig:"GRAFANA_ORG_ID" required:"false"`
+
+	// Only one kind of Grafana credentials can be set: API key, service
+	// account token, username and password, or a file with a token.
+	GrafanaAPIKey              string `envconfig:"GRAFANA_API_KEY" required:"false"`
+	GrafanaServiceAccountToken string `envconfig:"GRAFANA_SERVICE_ACCOUNT_TOKEN" required:"false"`
+	GrafanaUsername            string `envconfig:"GRAFANA_USERNAME" required:"false"`
+	GrafanaPassword            string `envconfig:"GRAFANA_PASSWORD" required:"false"`
+	GrafanaTokenFile           string `envconfig:"GRAFANA_TOKEN_FILE" required:"false"`
+
+	GrafanaCAFile   string        `envconfig:"GRAFANA_CA_FILE" required:"false"`
+	GrafanaCertFile string        `envconfig:"GRAFANA_CERT_FILE" required:"false"`
+	GrafanaKeyFile  string        `envconfig:"GRAFANA_KEY_FILE" required:"false"`
+	GrafanaProxyURL string        `envconfig:"GRAFANA_PROXY_URL" required:"false"`
+	GrafanaTimeout  time.Duration `envconfig:"GRAFANA_TIMEOUT" default:"30s"`
+
+	// Rate limiting, retries and circuit breaker of Grafana clients. They
+	// apply to the primary Grafana and to each of the targets.
+	GrafanaRateLimit        float64       `envconfig:"GRAFANA_RATE_LIMIT" default:"10"`
+	GrafanaRateBurst        int           `envconfig:"GRAFANA_RATE_BURST" default:"20"`
+	GrafanaMaxRetries       int           `envconfig:"GRAFANA_MAX_RETRIES" default:"3"`
+	GrafanaRetryMinWait     time.Duration `envconfig:"GRAFANA_RETRY_MIN_WAIT" default:"500ms"`
+	GrafanaRetryMaxWait     time.Duration `envconfig:"GRAFANA_RETRY_MAX_WAIT" default:"30s"`
+	GrafanaBreakerThreshold int           `envconfig:"GRAFANA_BREAKER_THRESHOLD" default:"5"`
+	GrafanaBreakerCooldown  time.Duration `envconfig:"GRAFANA_BREAKER_COOLDOWN" default:"30s"`
+
+	// GrafanaRoutes direct annotations to dashboards and panels, in JSON.
+	// See grafana.Route for the fields.
+	GrafanaRoutes grafana.Routes `envconfig:"GRAFANA_ROUTES" required:"false"`
+
+	// GrafanaTargets are additional Grafana instances or organizations, in
+	// JSON. See grafana.Target for the fields.
+	GrafanaTargets grafana.Targets `envconfig:"GRAFANA_TARGETS" required:"false"`
+
+	// GrafanaGCInterval enables periodic deletion of annotations of releases
+	// that no longer exist in the primary Grafana and each of the targets.
+	// GrafanaGCPolicy tells which of them to delete, see grafana.GCPolicy.
+	// Garbage collection requires ClusterName and cannot be used with push
+	// API, see checkGrafanaGC.
+	GrafanaGCInterval time.Duration    `envconfig:"GRAFANA_GC_INTERVAL" required:"false"`
+	GrafanaGCPolicy   grafana.GCPolicy `envconfig:"GRAFANA_GC_POLICY" default:"purged"`
+	GrafanaGCDryRun   bool             `envconfig:"GRAFANA_GC_DRY_RUN" required:"false"`
+
+	// LokiAddr enables Loki sink when set.
+	LokiAddr      string        `envconfig:"LOKI_ADDR" required:"false"`
+	LokiTenantID  string        `envconfig:"LOKI_TENANT_ID" required:"false"`
+	LokiBatchSize int           `envconfig:"LOKI_BATCH_SIZE" default:"100"`
+	LokiBatchWait time.Duration `envconfig:"LOKI_BATCH_WAIT" default:"5s"`
+
+	// ElasticsearchAddr enables Elasticsearch (or OpenSearch) sink when set.
+	ElasticsearchAddr            string        `envconfig:"ELASTICSEARCH_ADDR" required:"false"`
+	ElasticsearchUsername        string        `envconfig:"ELASTICSEARCH_USERNAME" required:"false"`
+	ElasticsearchPassword        string        `envconfig:"ELASTICSEARCH_PASSWORD" required:"false"`
+	ElasticsearchIndexPrefix     string        `envconfig:"ELASTICSEARCH_INDEX_PREFIX" default:"chronologist"`
+	ElasticsearchIndexDateLayout string        `envconfig:"ELASTICSEARCH_INDEX_DATE_LAYOUT" default:"2006.01"`
+	ElasticsearchSoftDelete      bool          `envconfig:"ELASTICSEARCH_SOFT_DELETE" default:"false"`
+	ElasticsearchBatchSize       int           `envconfig:"ELASTICSEARCH_BATCH_SIZE" default:"100"`
+	ElasticsearchBatchWait       time.Duration `envconfig:"ELASTICSEARCH_BATCH_WAIT" default:"5s"`
+
+	// InfluxDBAddr enables InfluxDB sink when set. Use database with
+	// username and password for InfluxDB 1.x, or org, bucket and token
+	// for InfluxDB 2.x.
+	InfluxDBAddr     string `envconfig:"INFLUXDB_ADDR" required:"false"`
+	InfluxDBDatabase string `envconfig:"INFLUXDB_DATABASE" required:"false"`
+	InfluxDBUsername string `envconfig:"INFLUXDB_USERNAME" required:"false"`
+	InfluxDBPassword string `envconfig:"INFLUXDB_PASSWORD" required:"false"`
+	InfluxDBOrg      string `envconfig:"INFLUXDB_ORG" required:"false"`
+	InfluxDBBucket   string `envconfig:"INFLUXDB_BUCKET" required:"false"`
+	InfluxDBToken    string `envconfig:"INFLUXDB_TOKEN" required:"false"`
+
+	// GraphiteAddr enables Graphite events sink when set.
+	GraphiteAddr     string `envconfig:"GRAPHITE_ADDR" required:"false"`
+	GraphiteUsername string `envconfig:"GRAPHITE_USERNAME" required:"false"`
+	GraphitePassword string `envconfig:"GRAPHITE_PASSWORD" required:"false"`
+
+	// DatadogAPIKey enables Datadog events sink when set. The application
+	// key is optional and allows to look up existing events on restart;
+	// without it, events are posted again on every restart.
+	DatadogAddr   string `envconfig:"DATADOG_ADDR" default:"https://api.datadoghq.com"`
+	DatadogAPIKey string `envconfig:"DATADOG_API_KEY" required:"false"`
+	DatadogAppKey string `envconfig:"DATADOG_APP_KEY" required:"false"`
+
+	// PagerDuty change events sink is enabled when any routing key is set.
+	// Routing keys are mapped by release name first, then by release label
+	// (e.g. "team=payments:KEY"), then by namespace, falling back to the
+	// default one. Only releases since chronologist started are sent. The
+	// link template is executed with the release event, e.g.
+	// "https://grafana.example.com/d/abc?var-release={{.Name}}", and can call
+	// grafanaDashboardURL for the dashboard the release is annotated on. It
+	// defaults to "{{grafanaDashboardURL .}}" when GrafanaAddr is set.
+	PagerDutyAddr                   string            `envconfig:"PAGERDUTY_ADDR" default:"https://events.pagerduty.com"`
+	PagerDutyRoutingKey             string            `envconfig:"PAGERDUTY_ROUTING_KEY" required:"false"`
+	PagerDutyRoutingKeysByLabel     map[string]string `envconfig:"PAGERDUTY_ROUTING_KEYS_BY_LABEL" required:"false"`
+	PagerDutyRoutingKeysByNamespace map[string]string `envconfig:"PAGERDUTY_ROUTING_KEYS_BY_NAMESPACE" required:"false"`
+	PagerDutyRoutingKeysByRelease   map[string]string `envconfig:"PAGERDUTY_ROUTING_KEYS_BY_RELEASE" required:"false"`
+	PagerDutyLinkTemplate           string            `envconfig:"PAGERDUTY_LINK_TEMPLATE" required:"false"`
+
+	// GitHubToken enables GitHub deployments sink when set. Releases are
+	// mapped to repositories with "chronologist.io/github-repository" chart
+	// annotation, or with the configured mapping, e.g. "api:acme/api".
+	GitHubAddr         string            `envconfig:"GITHUB_ADDR" default:"https://api.github.com"`
+	GitHubToken        string            `envconfig:"GITHUB_TOKEN" required:"false"`
+	GitHubRepositories map[string]string `envconfig:"GITHUB_REPOSITORIES" required:"false"`
+
+	// AlertmanagerAddr enables silencing alerts of releases during rollouts
+	// when set.
+	AlertmanagerAddr            string            `envconfig:"ALERTMANAGER_ADDR" required:"false"`
+	AlertmanagerSilenceDuration time.Duration     `envconfig:"ALERTMANAGER_SILENCE_DURATION" default:"30m"`
+	AlertmanagerNamespaceLabel  string            `envconfig:"ALERTMANAGER_NAMESPACE_LABEL" default:"namespace"`
+	AlertmanagerReleaseLabel    string            `envconfig:"ALERTMANAGER_RELEASE_LABEL" default:"release"`
+	AlertmanagerMatchers        map[string]string `envconfig:"ALERTMANAGER_MATCHERS" required:"false"`
+
+	// AuditLogPath enables JSON lines audit log sink when set.
+	AuditLogPath        string `envconfig:"AUDIT_LOG_PATH" required:"false"`
+	AuditLogMaxSize     int64  `envconfig:"AUDIT_LOG_MAX_SIZE" default:"104857600"`
+	AuditLogMaxSegments int    `envconfig:"AUDIT_LOG_MAX_SEGMENTS" default:"10"`
+	AuditLogCompress    bool   `envconfig:"AUDIT_LOG_COMPRESS" default:"false"`
 
 	ReleaseRevisionMaxAge time.Duration `envconfig:"RELEASE_REVISION_MAX_AGE" default:"24h"`
 
@@ -43,6 +170,21 @@ type Config struct {
 
 	WatchConfigMaps bool `envconfig:"WATCH_CONFIGMAPS" default:"true"`
 	WatchSecrets    bool `envconfig:"WATCH_SECRETS" default:"false"`
+
+	// DryRun makes Chronologist read annotations from Grafana, but only
+	// record changes it would make to the log and to the debug endpoint.
+	// Other sinks are disabled in dry run mode.
+	DryRun bool `envconfig:"DRY_RUN" default:"false"`
+
+	// DebugAddr is the address to serve debug endpoints on, e.g. changes
+	// planned in dry run mode at /debug/dry-run. Empty disables them.
+	DebugAddr string `envconfig:"DEBUG_ADDR" default:":8080"`
+
+	// PushAddr enables push API on the address when set, so that CI tools
+	// can report release events Chronologist cannot read from the cluster.
+	// Each request must carry the token, which is required therefore.
+	PushAddr  string `envconfig:"PUSH_ADDR" required:"false"`
+	PushToken string `envconfig:"PUSH_TOKEN" required:"false"`
 }
 
 // ConfigFromEnvironment returns specification loaded from environment
@@ -60,3 +202,93 @@ func ConfigFromEnvironment() (Config, error) {
 	s.KubeConfigPath = os.ExpandEnv(s.KubeConfigPath)
 	return s, nil
 }
+
+// disableBackground disables activities that run in background and are
+// not needed by one-shot commands.
+func (c *Config) disableBackground() {
+	c.GrafanaGCInterval = 0
+	c.DebugAddr = ""
+}
+
+// checkGrafanaGC returns an error if annotations of releases that exist
+// could be deleted as orphaned with the config: of other clusters sharing
+// Grafana when the cluster name is not set, or of releases pushed via push
+// API, which cannot be listed in the cluster.
+func (c Config) checkGrafanaGC() error {
+	if c.ClusterName == "" {
+		return errors.New("grafana gc requires cluster name, so annotations of other clusters are not deleted")
+	}
+	if c.PushAddr != "" {
+		return errors.New("grafana gc cannot be used with push api, as annotations of pushed releases would be deleted")
+	}
+	return nil
+}
+
+// GrafanaSettings returns settings of Grafana instances from the config.
+func (c Config) GrafanaSettings() grafana.Settings {
+	return grafana.Settings{
+		Addr:  c.GrafanaAddr,
+		OrgID: c.GrafanaOrgID,
+		Credentials: grafana.Credentials{
+			APIKey:              c.GrafanaAPIKey,
+			ServiceAccountToken: c.GrafanaServiceAccountToken,
+			Username:            c.GrafanaUsername,
+			Password:            c.GrafanaPassword,
+			TokenFile:           c.GrafanaTokenFile,
+		},
+		CAFile:   c.GrafanaCAFile,
+		CertFile: c.GrafanaCertFile,
+		KeyFile:  c.GrafanaKeyFile,
+		ProxyURL: c.GrafanaProxyURL,
+		Timeout:  c.GrafanaTimeout,
+		Routes:   c.GrafanaRoutes,
+		Targets:  c.GrafanaTargets,
+		Limits: grafana.Limits{
+			RateLimit: c.GrafanaRateLimit,
+			RateBurst: c.GrafanaRateBurst,
+			Retry: grafana.RetryOptions{
+				MaxRetries: c.GrafanaMaxRetries,
+				MinWait:    c.GrafanaRetryMinWait,
+				MaxWait:    c.GrafanaRetryMaxWait,
+			},
+			BreakerThreshold: c.GrafanaBreakerThreshold,
+			BreakerCooldown:  c.GrafanaBreakerCooldown,
+		},
+		Cluster: c.ClusterName,
+	}
+}
+
+// AddFlags adds flags that override the config to the flag set. Defaults of
+// the flags are taken from the config, so flags are layered over environment
+// variables.
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.KubeConfigPath, "kubeconfig", c.KubeConfigPath, "path to the kubeconfig file; in-cluster config is used when empty")
+	fs.StringVar(&c.ClusterName, "cluster-name", c.ClusterName, "name of the cluster Chronologist runs in")
+	fs.StringVar(&c.GrafanaAddr, "grafana-addr", c.GrafanaAddr, "address of the primary Grafana")
+	fs.DurationVar(&c.ReleaseRevisionMaxAge, "max-age", c.ReleaseRevisionMaxAge, "maximum age of release revisions to operate on")
+	fs.BoolVar(&c.WatchConfigMaps, "watch-configmaps", c.WatchConfigMaps, "read releases from configmaps")
+	fs.BoolVar(&c.WatchSecrets, "watch-secrets", c.WatchSecrets, "read releases from secrets")
+	fs.Var(textValue{&c.LogFormat}, "log-format", "log format: json or console")
+	fs.Var(textValue{&c.LogLevel.AtomicLevel}, "log-level", "log level: debug, info, warn or error")
+}
+
+// textValue adapts values that can be unmarshaled from text to flag.Value.
+type textValue struct {
+	v interface {
+		encoding.TextUnmarshaler
+		fmt.Stringer
+	}
+}
+
+// Set implements flag.Value.
+func (t textValue) Set(s string) error {
+	return t.v.UnmarshalText([]byte(s))
+}
+
+// String implements flag.Value.
+func (t textValue) String() string {
+	if t.v == nil {
+		return ""
+	}
+	return t.v.String()
+}
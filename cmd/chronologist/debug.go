@@ -0,0 +1,40 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/hypnoglow/chronologist/internal/grafana"
+)
+
+// dryRunHandler returns a handler of /debug/dry-run that responds with
+// changes planned in each Grafana, by names of Grafana instances.
+func dryRunHandler(dryRuns map[string]*grafana.DryRun) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/dry-run", func(w http.ResponseWriter, r *http.Request) {
+		ops := make(map[string][]grafana.Operation, len(dryRuns))
+		for name, dr := range dryRuns {
+			ops[name] = dr.Operations()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ops)
+	})
+	return mux
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/grafana"
+)
+
+// exportedAnnotation is an annotation along with the Grafana it is exported
+// from and the release event it describes.
+type exportedAnnotation struct {
+	Grafana string `json:"grafana"`
+	grafana.Annotation
+	Release   string `json:"release"`
+	Revision  string `json:"revision"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+}
+
+// runExport dumps annotations created by Chronologist as JSON lines, e.g. to
+// back them up before migrating to another Grafana.
+func runExport(args []string) error {
+	fs, conf, err := newFlagSet("export", "")
+	if err != nil {
+		return err
+	}
+	name := fs.String("grafana", "", "name of the Grafana target to export from, or \"primary\"; all by default")
+	since := fs.String("since", "", "export annotations within this period, e.g. 90d or 12h; all by default")
+	output := fs.String("output", "-", "file to write to; standard output by default")
+	log, err := parseFlags(fs, conf, args)
+	if err != nil {
+		return err
+	}
+
+	var from time.Time
+	if *since != "" {
+		period, err := parsePeriod(*since)
+		if err != nil {
+			return errors.Wrap(err, "parse since")
+		}
+		from = time.Now().Add(-period)
+	}
+
+	instances, _, err := newGrafanaInstances(*conf, log)
+	if err != nil {
+		return err
+	}
+	instances, err = selectGrafanaInstances(instances, *name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if *output == "-" {
+		return exportAnnotations(ctx, os.Stdout, instances, *conf, from, log)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return errors.Wrap(err, "create output file")
+	}
+	err = exportAnnotations(ctx, f, instances, *conf, from, log)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		return errors.Wrap(cerr, "close output file")
+	}
+	return err
+}
+
+// exportAnnotations writes annotations of the Grafana instances created
+// since from to out.
+func exportAnnotations(ctx context.Context, out io.Writer, instances []grafana.Instance, conf Config, from time.Time, log *zap.Logger) error {
+	enc := json.NewEncoder(out)
+	for _, g := range instances {
+		q := grafana.GetAnnotationsParams{Type: grafana.TypeAnnotation, From: from}
+		q.Owned(conf.ClusterName, g.Options.Target)
+		aa, err := g.Client.GetAnnotations(ctx, q)
+		if err != nil {
+			return errors.Wrapf(err, "get annotations from grafana %s", g.Name)
+		}
+		aa = aa.OfTarget(g.Options.Target)
+
+		for _, a := range aa {
+			re := a.ToReleaseEvent()
+			err := enc.Encode(exportedAnnotation{
+				Grafana:    g.Name,
+				Annotation: a,
+				Release:    re.Name,
+				Revision:   re.Revision,
+				Namespace:  re.Namespace,
+				Status:     re.Status,
+			})
+			if err != nil {
+				return errors.Wrap(err, "write annotation")
+			}
+		}
+		log.Sugar().Infof("Exported %d annotations from grafana %s", len(aa), g.Name)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/grafana/fake"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// runFakeGrafana runs the in-memory fake Grafana until a signal is received,
+// so Chronologist can be developed locally without a live Grafana.
+func runFakeGrafana(args []string) error {
+	flags := flag.NewFlagSet("fake-grafana", flag.ContinueOnError)
+	addr := flags.String("addr", ":3000", "address to listen on")
+	version := flags.String("version", fake.DefaultVersion, "version of Grafana to report")
+	apiKey := flags.String("api-key", "", "API key to require, if any")
+	latency := flags.Duration("latency", 0, "delay of every response")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	log, err := zaplog.New(zaplog.FormatConsole, zaplog.Level{AtomicLevel: zap.NewAtomicLevelAt(zap.InfoLevel)})
+	if err != nil {
+		return errors.Wrap(err, "create logger")
+	}
+
+	g := fake.New()
+	g.SetVersion(*version)
+	g.SetAPIKey(*apiKey)
+	g.SetLatency(*latency)
+
+	srv := &http.Server{
+		Addr: *addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Info("Request", zap.String("method", r.Method), zap.String("url", r.URL.String()))
+			g.ServeHTTP(w, r)
+		}),
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	log.Info("Fake Grafana is listening", zap.String("addr", *addr), zap.String("version", *version))
+
+	go func() {
+		waitForSignal()
+
+		log.Info("Shutting down ...")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	if err := <-errCh; err != http.ErrServerClosed {
+		return errors.Wrap(err, "serve")
+	}
+	return nil
+}
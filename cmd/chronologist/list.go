@@ -0,0 +1,172 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/grafana"
+)
+
+// Sync statuses of release events.
+const (
+	syncStatusSynced     = "synced"
+	syncStatusOutdated   = "outdated"
+	syncStatusMissing    = "missing"
+	syncStatusDuplicated = "duplicated"
+	syncStatusOrphaned   = "orphaned"
+)
+
+// runList prints releases along with the sync status of their annotations
+// in Grafana, and annotations of releases that no longer exist.
+func runList(args []string) error {
+	fs, conf, err := newFlagSet("list", "")
+	if err != nil {
+		return err
+	}
+	name := fs.String("grafana", "", "name of the Grafana target to compare with, or \"primary\"; all by default")
+	all := fs.Bool("all", false, "list releases of any age, not only those within max age")
+	log, err := parseFlags(fs, conf, args)
+	if err != nil {
+		return err
+	}
+
+	lister, err := newLister(*conf)
+	if err != nil {
+		return err
+	}
+
+	instances, _, err := newGrafanaInstances(*conf, log)
+	if err != nil {
+		return err
+	}
+	instances, err = selectGrafanaInstances(instances, *name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	events, err := listReleaseEvents(ctx, lister, log)
+	if err != nil {
+		return errors.Wrap(err, "list releases")
+	}
+
+	exists := make(map[string]bool)
+	var listed []chronologist.ReleaseEvent
+	for _, re := range events {
+		exists[re.Name+"/"+re.Revision] = true
+		if *all || conf.ReleaseRevisionMaxAge == 0 || time.Since(re.Time) <= conf.ReleaseRevisionMaxAge {
+			listed = append(listed, re)
+		}
+	}
+	sortReleaseEvents(listed)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GRAFANA\tNAMESPACE\tRELEASE\tREVISION\tSTATUS\tDEPLOYED\tANNOTATIONS\tSYNC")
+
+	for _, g := range instances {
+		q := grafana.GetAnnotationsParams{Type: grafana.TypeAnnotation}
+		q.Owned(conf.ClusterName, g.Options.Target)
+		aa, err := g.Client.GetAnnotations(ctx, q)
+		if err != nil {
+			return errors.Wrapf(err, "get annotations from grafana %s", g.Name)
+		}
+		aa = aa.OfTarget(g.Options.Target)
+
+		byRevision := make(map[string]grafana.Annotations)
+		var orphans []chronologist.ReleaseEvent
+		for _, a := range aa {
+			re := a.ToReleaseEvent()
+			key := re.Name + "/" + re.Revision
+			if !exists[key] && len(byRevision[key]) == 0 {
+				orphans = append(orphans, re)
+			}
+			byRevision[key] = append(byRevision[key], a)
+		}
+		sortReleaseEvents(orphans)
+
+		for _, re := range listed {
+			if !g.Options.Selector.Matches(re) {
+				continue
+			}
+			anns := byRevision[re.Name+"/"+re.Revision]
+			printListRow(w, g.Name, re, anns, syncStatus(re, anns))
+		}
+		for _, re := range orphans {
+			printListRow(w, g.Name, re, byRevision[re.Name+"/"+re.Revision], syncStatusOrphaned)
+		}
+	}
+
+	return w.Flush()
+}
+
+// syncStatus returns the sync status of the release event with its
+// annotations.
+func syncStatus(re chronologist.ReleaseEvent, aa grafana.Annotations) string {
+	switch {
+	case len(aa) == 0:
+		return syncStatusMissing
+	case len(aa) > 1:
+		return syncStatusDuplicated
+	case len(re.Differences(aa[0].ToReleaseEvent())) > 0:
+		return syncStatusOutdated
+	default:
+		return syncStatusSynced
+	}
+}
+
+func printListRow(w *tabwriter.Writer, grafanaName string, re chronologist.ReleaseEvent, aa grafana.Annotations, status string) {
+	ids := []string{}
+	for _, a := range aa {
+		ids = append(ids, strconv.Itoa(a.ID))
+	}
+	if len(ids) == 0 {
+		ids = append(ids, "-")
+	}
+
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		grafanaName, re.Namespace, re.Name, re.Revision, re.Status,
+		re.Time.Format(time.RFC3339), strings.Join(ids, ","), status,
+	)
+}
+
+// sortReleaseEvents sorts release events by namespace, name and revision.
+func sortReleaseEvents(events []chronologist.ReleaseEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		ra, _ := strconv.Atoi(a.Revision)
+		rb, _ := strconv.Atoi(b.Revision)
+		return ra < rb
+	})
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/controller"
+	"github.com/hypnoglow/chronologist/internal/kube"
+)
+
+// newLister returns a lister of all releases stored in the configmaps
+// (or secrets) of the cluster, regardless of ReleaseRevisionMaxAge.
+func newLister(conf Config) (chronologist.Lister, error) {
+	_, kubeClient, err := kube.NewConfigAndClient(conf.KubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "create kubernetes client")
+	}
+
+	return controller.NewLister(kubeClient, controller.Options{
+		WatchConfigMaps: conf.WatchConfigMaps,
+		WatchSecrets:    conf.WatchSecrets,
+	})
+}
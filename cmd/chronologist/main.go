@@ -17,62 +17,120 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
-	"sync"
+	"strings"
 	"syscall"
 
-	"github.com/hypnoglow/chronologist/internal/controller"
-	"github.com/hypnoglow/chronologist/internal/grafana"
-	"github.com/hypnoglow/chronologist/internal/kube"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
 	"github.com/hypnoglow/chronologist/internal/zaplog"
 )
 
+// command is a subcommand of chronologist.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands of chronologist. Without a command, the controller is run.
+var commands = []command{
+	{name: "run", summary: "Run the controller (default)", run: runController},
+	{name: "serve", summary: "Serve push API only, without reading releases from the cluster", run: runServe},
+	{name: "sync", summary: "Force-reconcile annotations of a release", run: runSync},
+	{name: "list", summary: "List releases and the sync status of their annotations", run: runList},
+	{name: "prune", summary: "Delete annotations of releases that no longer exist", run: runPrune},
+	{name: "export", summary: "Dump annotations as JSON lines", run: runExport},
+	{name: "reconcile", summary: "Reconcile all releases once, e.g. in a CronJob", run: runReconcile},
+	{name: "backfill", summary: "Import releases older than the max age", run: runBackfill},
+	{name: "fake-grafana", summary: "Run an in-memory fake Grafana for development", run: runFakeGrafana},
+}
+
 func main() {
-	conf, err := ConfigFromEnvironment()
-	if err != nil {
-		panic("failed to get config from environment: " + err.Error())
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	log, err := zaplog.New(conf.LogFormat, conf.LogLevel)
-	if err != nil {
-		panic("failed to create logger: " + err.Error())
+	if name == "help" {
+		usage()
+		return
 	}
 
-	_, kubeClient, err := kube.NewConfigAndClient(conf.KubeConfigPath)
-	if err != nil {
-		panic("failed to create kubernetes client: " + err.Error())
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		err := cmd.run(args)
+		if err == flag.ErrHelp {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "chronologist %s: %s\n", name, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	grafanaClient := grafana.NewClient(conf.GrafanaAddr, conf.GrafanaAPIKey)
+	fmt.Fprintf(os.Stderr, "chronologist: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-	chronicle := grafana.NewChronicle(grafanaClient, log)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: chronologist <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'chronologist <command> -h' for flags of the command.\n")
+}
 
-	c, err := controller.New(log, kubeClient, chronicle, controller.Options{
-		MaxAge:          conf.ReleaseRevisionMaxAge,
-		WatchConfigMaps: conf.WatchConfigMaps,
-		WatchSecrets:    conf.WatchSecrets,
-	})
+// newFlagSet loads the config from environment and returns a flag set of the
+// command with flags that override the config. Args describes positional
+// arguments of the command, if any.
+func newFlagSet(name, args string) (*flag.FlagSet, *Config, error) {
+	conf, err := ConfigFromEnvironment()
 	if err != nil {
-		panic("failed to create controller: " + err.Error())
+		return nil, nil, errors.Wrap(err, "get config from environment")
 	}
 
-	stopCh := make(chan struct{})
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: chronologist %s\n\nFlags:\n", strings.TrimSpace(name+" [flags] "+args))
+		fs.PrintDefaults()
+	}
+	conf.AddFlags(fs)
+	return fs, &conf, nil
+}
 
-	wg := sync.WaitGroup{}
-	defer wg.Wait()
+// parseFlags parses args of the command, overriding the config, and returns
+// a logger configured by it.
+func parseFlags(fs *flag.FlagSet, conf *Config, args []string) (*zap.Logger, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	log, err := zaplog.New(conf.LogFormat, conf.LogLevel)
+	if err != nil {
+		return nil, errors.Wrap(err, "create logger")
+	}
+	return log, nil
+}
 
+// signalContext returns a context that is canceled when a signal is received.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
 		waitForSignal()
-
-		log.Info("Shutting down ...")
-		close(stopCh)
+		cancel()
 	}()
-
-	c.Run(stopCh)
+	return ctx, cancel
 }
 
 func waitForSignal() {
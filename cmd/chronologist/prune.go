@@ -0,0 +1,77 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/chronologist/internal/problems"
+)
+
+// runPrune deletes annotations of releases that no longer exist, as the
+// garbage collector does periodically.
+func runPrune(args []string) error {
+	fs, conf, err := newFlagSet("prune", "")
+	if err != nil {
+		return err
+	}
+	name := fs.String("grafana", "", "name of the Grafana target to prune, or \"primary\"; all by default")
+	fs.BoolVar(&conf.GrafanaGCDryRun, "dry-run", conf.GrafanaGCDryRun, "only report annotations that would be deleted")
+	fs.Var(textValue{&conf.GrafanaGCPolicy}, "policy", "which orphaned annotations to delete: purged or revision")
+	log, err := parseFlags(fs, conf, args)
+	if err != nil {
+		return err
+	}
+	if err = conf.checkGrafanaGC(); err != nil {
+		return err
+	}
+	// Nothing is deleted in dry run mode.
+	conf.GrafanaGCDryRun = conf.GrafanaGCDryRun || conf.DryRun
+
+	lister, err := newLister(*conf)
+	if err != nil {
+		return err
+	}
+
+	instances, _, err := newGrafanaInstances(*conf, log)
+	if err != nil {
+		return err
+	}
+	instances, err = selectGrafanaInstances(instances, *name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	var errs []error
+	for _, g := range instances {
+		report, err := newGrafanaCollector(*conf, g.Client, g.Options.Target, lister, g.Log).Collect(ctx)
+		for _, a := range report.Orphans {
+			re := a.ToReleaseEvent()
+			fmt.Printf("%s: orphaned annotation %d of release %s revision %s\n", g.Name, a.ID, re.Name, re.Revision)
+		}
+		fmt.Printf("%s: %s\n", g.Name, report)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "prune grafana %s", g.Name))
+		}
+	}
+	return problems.NewAggregate(errs)
+}
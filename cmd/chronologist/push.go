@@ -0,0 +1,90 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/pushapi"
+)
+
+// runServe serves push API only, without reading releases from the cluster,
+// until a signal is received.
+func runServe(args []string) error {
+	fs, conf, err := newFlagSet("serve", "")
+	if err != nil {
+		return err
+	}
+	fs.StringVar(&conf.PushAddr, "push-addr", conf.PushAddr, "address to serve push API on")
+	fs.BoolVar(&conf.DryRun, "dry-run", conf.DryRun, "only record changes in Grafana instead of making them")
+	log, err := parseFlags(fs, conf, args)
+	if err != nil {
+		return err
+	}
+	if conf.PushAddr == "" {
+		return errors.New("push API address is required")
+	}
+
+	// Releases are not listed in this mode, so there is nothing to check
+	// annotations against.
+	conf.GrafanaGCInterval = 0
+
+	chronicle, runners, err := newChronicle(*conf, nil, log)
+	if err != nil {
+		return errors.Wrap(err, "create chronicle")
+	}
+
+	push, err := newPushServer(*conf, chronicle, log)
+	if err != nil {
+		return err
+	}
+	runners = append(runners, push)
+
+	stopCh := make(chan struct{})
+
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	for _, r := range runners {
+		wg.Add(1)
+		go func(r runner) {
+			defer wg.Done()
+			r.Run(stopCh)
+		}(r)
+	}
+
+	waitForSignal()
+
+	log.Info("Shutting down ...")
+	close(stopCh)
+	return nil
+}
+
+// newPushServer returns a runner that serves push API, which feeds pushed
+// release events into the chronicle.
+func newPushServer(conf Config, chronicle chronologist.Chronicle, log *zap.Logger) (runner, error) {
+	if conf.PushToken == "" {
+		return nil, errors.New("push API token is required")
+	}
+
+	h := pushapi.NewHandler(chronicle, log, pushapi.Options{Token: conf.PushToken})
+	return newHTTPServer("push API", conf.PushAddr, h, log), nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/problems"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// runReconcile reconciles all releases with the chronicle once and exits,
+// so Chronologist can run as a CronJob or as a CI step after a deploy
+// instead of running the controller. It registers release events within
+// the max age, deletes annotations of releases that no longer exist in
+// Grafana, prints a summary and fails if anything failed.
+func runReconcile(args []string) error {
+	fs, conf, err := newFlagSet("reconcile", "")
+	if err != nil {
+		return err
+	}
+	fs.BoolVar(&conf.DryRun, "dry-run", conf.DryRun, "only record changes in Grafana instead of making them")
+	prune := fs.Bool("prune", true, "delete annotations of releases that no longer exist, as the gc policy allows")
+	log, err := parseFlags(fs, conf, args)
+	if err != nil {
+		return err
+	}
+	conf.disableBackground()
+
+	lister, err := newLister(*conf)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	// Releases are listed once, so everything is reconciled against the
+	// same state of the cluster.
+	events, listErr := lister.ListReleaseEvents(ctx)
+	if listErr != nil && events == nil {
+		return errors.Wrap(listErr, "list releases")
+	}
+	releases := releaseEvents(events)
+
+	instances, runners, err := newGrafanaInstances(*conf, log)
+	if err != nil {
+		return err
+	}
+	chronicle, sinkRunners, err := newChronicleWithGrafana(*conf, instances, releases, log)
+	if err != nil {
+		return errors.Wrap(err, "create chronicle")
+	}
+	stop := startRunners(append(runners, sinkRunners...))
+	defer stop()
+
+	var errs []error
+	if listErr != nil {
+		errs = append(errs, errors.Wrap(listErr, "list releases"))
+	}
+
+	registered, failed := 0, 0
+	for _, re := range events {
+		if conf.ReleaseRevisionMaxAge != 0 && time.Since(re.Time) > conf.ReleaseRevisionMaxAge {
+			continue
+		}
+
+		rctx := zaplog.WithFields(ctx, zap.String("release", re.Name), zap.String("revision", re.Revision))
+		if err := chronicle.Register(rctx, re); err != nil {
+			log.Sugar().Errorf("Failed to reconcile release %s revision %s: %s", re.Name, re.Revision, err)
+			failed++
+			continue
+		}
+		registered++
+	}
+	fmt.Printf("Reconciled %d release events, %d failed\n", registered, failed)
+	if failed > 0 {
+		errs = append(errs, errors.Errorf("failed to reconcile %d release events", failed))
+	}
+
+	switch {
+	case !*prune:
+	case listErr != nil:
+		// Annotations of releases that cannot be read would be deleted.
+		fmt.Println("Skipped pruning, as some releases cannot be read")
+	case conf.checkGrafanaGC() != nil:
+		// Annotations of other clusters or of pushed releases would be deleted.
+		fmt.Println("Skipped pruning, as it requires cluster name and cannot be used with push API")
+	default:
+		// Nothing is deleted in dry run mode.
+		conf.GrafanaGCDryRun = conf.GrafanaGCDryRun || conf.DryRun
+		for _, g := range instances {
+			report, err := newGrafanaCollector(*conf, g.Client, g.Options.Target, releases, g.Log).Collect(ctx)
+			fmt.Printf("Pruned grafana %s: %s\n", g.Name, report)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "prune grafana %s", g.Name))
+			}
+		}
+	}
+
+	return problems.NewAggregate(errs)
+}
+
+// releaseEvents is a chronologist.Lister of the release events.
+type releaseEvents []chronologist.ReleaseEvent
+
+// ListReleaseEvents implements chronologist.Lister.
+func (l releaseEvents) ListReleaseEvents(ctx context.Context) ([]chronologist.ReleaseEvent, error) {
+	return l, nil
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+)
+
+// listReleaseEvents lists release events of all releases. Releases that
+// cannot be read are skipped with a warning.
+func listReleaseEvents(ctx context.Context, releases chronologist.Lister, log *zap.Logger) ([]chronologist.ReleaseEvent, error) {
+	events, err := releases.ListReleaseEvents(ctx)
+	if err != nil && events == nil {
+		return nil, err
+	}
+	if err != nil {
+		log.Sugar().Warnf("Some releases cannot be read, skipping them: %s", err)
+	}
+	return events, nil
+}
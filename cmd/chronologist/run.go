@@ -0,0 +1,100 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/chronologist/internal/controller"
+	"github.com/hypnoglow/chronologist/internal/kube"
+)
+
+// runController runs the controller that syncs release events with the
+// chronicle until a signal is received.
+func runController(args []string) error {
+	fs, conf, err := newFlagSet("run", "")
+	if err != nil {
+		return err
+	}
+	fs.BoolVar(&conf.DryRun, "dry-run", conf.DryRun, "only record changes in Grafana instead of making them")
+	log, err := parseFlags(fs, conf, args)
+	if err != nil {
+		return err
+	}
+
+	_, kubeClient, err := kube.NewConfigAndClient(conf.KubeConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "create kubernetes client")
+	}
+
+	opts := controller.Options{
+		MaxAge:          conf.ReleaseRevisionMaxAge,
+		WatchConfigMaps: conf.WatchConfigMaps,
+		WatchSecrets:    conf.WatchSecrets,
+	}
+
+	lister, err := controller.NewLister(kubeClient, opts)
+	if err != nil {
+		return errors.Wrap(err, "create release lister")
+	}
+
+	chronicle, runners, err := newChronicle(*conf, lister, log)
+	if err != nil {
+		return errors.Wrap(err, "create chronicle")
+	}
+
+	if conf.PushAddr != "" {
+		push, err := newPushServer(*conf, chronicle, log)
+		if err != nil {
+			return err
+		}
+		runners = append(runners, push)
+	}
+
+	c, err := controller.New(log, kubeClient, chronicle, opts)
+	if err != nil {
+		return errors.Wrap(err, "create controller")
+	}
+
+	stopCh := make(chan struct{})
+
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		waitForSignal()
+
+		log.Info("Shutting down ...")
+		close(stopCh)
+	}()
+
+	for _, r := range runners {
+		wg.Add(1)
+		go func(r runner) {
+			defer wg.Done()
+			r.Run(stopCh)
+		}(r)
+	}
+
+	c.Run(stopCh)
+	return nil
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newHTTPServer returns a runner that serves the handler on the address
+// until stopped. What tells what is served, for logs.
+func newHTTPServer(what, addr string, handler http.Handler, log *zap.Logger) runner {
+	return runnerFunc(func(stopCh <-chan struct{}) {
+		srv := &http.Server{
+			Addr:    addr,
+			Handler: handler,
+		}
+
+		go func() {
+			<-stopCh
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(ctx)
+		}()
+
+		log.Sugar().Infof("Serving %s on %s", what, addr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Sugar().Errorf("Failed to serve %s: %s", what, err)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/problems"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// runSync registers all revisions of the release in the chronicle regardless
+// of their age, e.g. to restore annotations that were changed or deleted
+// by hand.
+func runSync(args []string) error {
+	fs, conf, err := newFlagSet("sync", "<release>")
+	if err != nil {
+		return err
+	}
+	revision := fs.String("revision", "", "sync only this revision of the release")
+	log, err := parseFlags(fs, conf, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("release name is required")
+	}
+	name := fs.Arg(0)
+
+	conf.disableBackground()
+
+	lister, err := newLister(*conf)
+	if err != nil {
+		return err
+	}
+
+	chronicle, runners, err := newChronicle(*conf, lister, log)
+	if err != nil {
+		return errors.Wrap(err, "create chronicle")
+	}
+	stop := startRunners(runners)
+	defer stop()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	events, err := listReleaseEvents(ctx, lister, log)
+	if err != nil {
+		return errors.Wrap(err, "list releases")
+	}
+
+	synced := 0
+	var errs []error
+	for _, re := range events {
+		if re.Name != name || (*revision != "" && re.Revision != *revision) {
+			continue
+		}
+
+		rctx := zaplog.WithFields(ctx, zap.String("release", re.Name), zap.String("revision", re.Revision))
+		if err := chronicle.Register(rctx, re); err != nil {
+			errs = append(errs, errors.Wrapf(err, "sync revision %s", re.Revision))
+			continue
+		}
+		synced++
+	}
+
+	if synced == 0 && len(errs) == 0 {
+		if *revision != "" {
+			return errors.Errorf("release %s revision %s not found", name, *revision)
+		}
+		return errors.Errorf("release %s not found", name)
+	}
+
+	fmt.Printf("Synced %d revisions of release %s\n", synced, name)
+	return problems.NewAggregate(errs)
+}
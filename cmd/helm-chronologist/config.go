@@ -0,0 +1,154 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/hypnoglow/chronologist/internal/grafana"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+const prefix = "chronologist"
+
+// Config is the configuration of the plugin. Grafana settings are taken from
+// the same environment variables as the ones of Chronologist.
+type Config struct {
+	// KubeConfigPath defaults to the kubeconfig of the user, see
+	// defaultKubeConfigPath.
+	KubeConfigPath string `envconfig:"KUBECONFIG" required:"false"`
+
+	// TillerNamespace is the namespace Tiller stores releases in. Helm
+	// passes it to plugins as TILLER_NAMESPACE, see ConfigFromEnvironment.
+	TillerNamespace string `ignored:"true"`
+
+	// Storage is the storage backend of Tiller: "configmap" or "secret".
+	Storage string `envconfig:"STORAGE" default:"configmap"`
+
+	ClusterName string `envconfig:"CLUSTER_NAME" required:"false"`
+
+	GrafanaAddr                string `envconfig:"GRAFANA_ADDR" required:"false"`
+	GrafanaOrgID               int64  `envconfig:"GRAFANA_ORG_ID" required:"false"`
+	GrafanaAPIKey              string `envconfig:"GRAFANA_API_KEY" required:"false"`
+	GrafanaServiceAccountToken string `envconfig:"GRAFANA_SERVICE_ACCOUNT_TOKEN" required:"false"`
+	GrafanaUsername            string `envconfig:"GRAFANA_USERNAME" required:"false"`
+	GrafanaPassword            string `envconfig:"GRAFANA_PASSWORD" required:"false"`
+	GrafanaTokenFile           string `envconfig:"GRAFANA_TOKEN_FILE" required:"false"`
+
+	GrafanaCAFile   string        `envconfig:"GRAFANA_CA_FILE" required:"false"`
+	GrafanaCertFile string        `envconfig:"GRAFANA_CERT_FILE" required:"false"`
+	GrafanaKeyFile  string        `envconfig:"GRAFANA_KEY_FILE" required:"false"`
+	GrafanaProxyURL string        `envconfig:"GRAFANA_PROXY_URL" required:"false"`
+	GrafanaTimeout  time.Duration `envconfig:"GRAFANA_TIMEOUT" default:"30s"`
+
+	GrafanaRateLimit        float64       `envconfig:"GRAFANA_RATE_LIMIT" default:"10"`
+	GrafanaRateBurst        int           `envconfig:"GRAFANA_RATE_BURST" default:"20"`
+	GrafanaMaxRetries       int           `envconfig:"GRAFANA_MAX_RETRIES" default:"3"`
+	GrafanaRetryMinWait     time.Duration `envconfig:"GRAFANA_RETRY_MIN_WAIT" default:"500ms"`
+	GrafanaRetryMaxWait     time.Duration `envconfig:"GRAFANA_RETRY_MAX_WAIT" default:"30s"`
+	GrafanaBreakerThreshold int           `envconfig:"GRAFANA_BREAKER_THRESHOLD" default:"5"`
+	GrafanaBreakerCooldown  time.Duration `envconfig:"GRAFANA_BREAKER_COOLDOWN" default:"30s"`
+
+	GrafanaRoutes  grafana.Routes  `envconfig:"GRAFANA_ROUTES" required:"false"`
+	GrafanaTargets grafana.Targets `envconfig:"GRAFANA_TARGETS" required:"false"`
+
+	ReleaseRevisionMaxAge time.Duration `envconfig:"RELEASE_REVISION_MAX_AGE" default:"24h"`
+
+	LogFormat zaplog.Format `envconfig:"LOG_FORMAT" default:"console"`
+	LogLevel  zaplog.Level  `envconfig:"LOG_LEVEL" default:"warn"`
+}
+
+// ConfigFromEnvironment returns configuration loaded from environment
+// variables.
+func ConfigFromEnvironment() (Config, error) {
+	// we do not care if there is no .env file.
+	_ = godotenv.Overload()
+
+	var c Config
+	if err := envconfig.Process(prefix, &c); err != nil {
+		return c, err
+	}
+
+	c.TillerNamespace = os.Getenv("TILLER_NAMESPACE")
+	if c.TillerNamespace == "" {
+		c.TillerNamespace = "kube-system"
+	}
+	if c.KubeConfigPath == "" {
+		c.KubeConfigPath = defaultKubeConfigPath()
+	}
+	return c, nil
+}
+
+// AddFlags adds flags that override the config to the flag set.
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.KubeConfigPath, "kubeconfig", c.KubeConfigPath, "path to the kubeconfig file")
+	fs.StringVar(&c.TillerNamespace, "tiller-namespace", c.TillerNamespace, "namespace Tiller stores releases in")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "storage backend of Tiller: configmap or secret")
+	fs.StringVar(&c.ClusterName, "cluster-name", c.ClusterName, "name of the cluster to tag annotations with")
+	fs.StringVar(&c.GrafanaAddr, "grafana-addr", c.GrafanaAddr, "address of Grafana")
+	fs.DurationVar(&c.ReleaseRevisionMaxAge, "max-age", c.ReleaseRevisionMaxAge, "maximum age of release revisions to sync; 0 syncs all of them")
+}
+
+// GrafanaSettings returns settings of Grafana instances from the config.
+func (c Config) GrafanaSettings() grafana.Settings {
+	return grafana.Settings{
+		Addr:  c.GrafanaAddr,
+		OrgID: c.GrafanaOrgID,
+		Credentials: grafana.Credentials{
+			APIKey:              c.GrafanaAPIKey,
+			ServiceAccountToken: c.GrafanaServiceAccountToken,
+			Username:            c.GrafanaUsername,
+			Password:            c.GrafanaPassword,
+			TokenFile:           c.GrafanaTokenFile,
+		},
+		CAFile:   c.GrafanaCAFile,
+		CertFile: c.GrafanaCertFile,
+		KeyFile:  c.GrafanaKeyFile,
+		ProxyURL: c.GrafanaProxyURL,
+		Timeout:  c.GrafanaTimeout,
+		Routes:   c.GrafanaRoutes,
+		Targets:  c.GrafanaTargets,
+		Limits: grafana.Limits{
+			RateLimit: c.GrafanaRateLimit,
+			RateBurst: c.GrafanaRateBurst,
+			Retry: grafana.RetryOptions{
+				MaxRetries: c.GrafanaMaxRetries,
+				MinWait:    c.GrafanaRetryMinWait,
+				MaxWait:    c.GrafanaRetryMaxWait,
+			},
+			BreakerThreshold: c.GrafanaBreakerThreshold,
+			BreakerCooldown:  c.GrafanaBreakerCooldown,
+		},
+		Cluster: c.ClusterName,
+	}
+}
+
+// defaultKubeConfigPath returns the first file of KUBECONFIG, or
+// ~/.kube/config, like kubectl does.
+func defaultKubeConfigPath() string {
+	if paths := os.Getenv("KUBECONFIG"); paths != "" {
+		return strings.Split(paths, string(filepath.ListSeparator))[0]
+	}
+	return filepath.Join(os.Getenv("HOME"), ".kube", "config")
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command helm-chronologist is a Helm plugin that annotates releases in
+// Grafana from the developer's machine, e.g. after "helm upgrade" against
+// a cluster where Chronologist controller does not run.
+//
+// It reads releases from the storage of Tiller using the kubeconfig of the
+// user, and syncs them with Grafana annotations the same way the controller
+// does.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/grafana"
+	"github.com/hypnoglow/chronologist/internal/problems"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "helm chronologist: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	conf, err := ConfigFromEnvironment()
+	if err != nil {
+		return errors.Wrap(err, "load configuration from environment")
+	}
+
+	fs := flag.NewFlagSet("helm chronologist", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Annotate releases in Grafana.\n\nUsage:\n  helm chronologist [flags] <release>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	conf.AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("release name is required")
+	}
+
+	log, err := zaplog.New(conf.LogFormat, conf.LogLevel)
+	if err != nil {
+		return errors.Wrap(err, "create logger")
+	}
+
+	chronicle, err := newChronicle(conf, log)
+	if err != nil {
+		return err
+	}
+
+	releases, err := newReleaseReader(conf)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range fs.Args() {
+		if err := syncRelease(context.Background(), releases, chronicle, name, conf.ReleaseRevisionMaxAge); err != nil {
+			errs = append(errs, errors.Wrapf(err, "sync release %s", name))
+		}
+	}
+	return problems.NewAggregate(errs)
+}
+
+// releaseReader reads release events of a release from the storage.
+type releaseReader interface {
+	ReleaseEvents(name string) ([]chronologist.ReleaseEvent, error)
+}
+
+// syncRelease registers revisions of the release deployed within max age
+// in the chronicle, and prints the result of each. Zero max age means
+// all revisions.
+func syncRelease(ctx context.Context, releases releaseReader, chronicle chronologist.Chronicle, name string, maxAge time.Duration) error {
+	var errs []error
+
+	// Revisions that can be read are synced even if others cannot.
+	events, err := releases.ReleaseEvents(name)
+	if err != nil {
+		if len(events) == 0 {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	if len(events) == 0 {
+		return errors.New("release not found")
+	}
+
+	for _, re := range events {
+		if maxAge > 0 && time.Since(re.Time) > maxAge {
+			fmt.Printf("%s revision %s (%s): skipped, deployed more than %s ago\n", re.Name, re.Revision, re.Status, maxAge)
+			continue
+		}
+
+		if err := chronicle.Register(ctx, re); err != nil {
+			fmt.Printf("%s revision %s (%s): failed\n", re.Name, re.Revision, re.Status)
+			errs = append(errs, errors.Wrapf(err, "register revision %s", re.Revision))
+			continue
+		}
+		fmt.Printf("%s revision %s (%s): synced\n", re.Name, re.Revision, re.Status)
+	}
+	return problems.NewAggregate(errs)
+}
+
+// newChronicle returns a chronicle of the primary Grafana and of the Grafana
+// targets set in the config.
+func newChronicle(conf Config, log *zap.Logger) (chronologist.Chronicle, error) {
+	// Runners of the credentials are not started: the token file is read
+	// once, which is enough for a single sync.
+	instances, _, err := grafana.NewInstances(conf.GrafanaSettings(), log)
+	if err != nil {
+		return nil, err
+	}
+
+	var chronicles chronologist.MultiChronicle
+	for _, g := range instances {
+		chronicles = append(chronicles, grafana.NewChronicle(g.Client, g.Log, g.Options))
+	}
+	return chronicles, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+)
+
+type stubReader struct {
+	events []chronologist.ReleaseEvent
+	err    error
+}
+
+func (r stubReader) ReleaseEvents(name string) ([]chronologist.ReleaseEvent, error) {
+	return r.events, r.err
+}
+
+type stubChronicle struct {
+	registered []string
+	failing    map[string]bool
+}
+
+func (c *stubChronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	if c.failing[re.Revision] {
+		return errors.New("grafana is down")
+	}
+	c.registered = append(c.registered, re.Revision)
+	return nil
+}
+
+func (c *stubChronicle) Unregister(ctx context.Context, name, revision string) error {
+	return nil
+}
+
+func TestSyncRelease(t *testing.T) {
+	now := time.Now()
+	events := []chronologist.ReleaseEvent{
+		{Name: "foo", Revision: "1", Status: "SUPERSEDED", Time: now.Add(-time.Hour * 48)},
+		{Name: "foo", Revision: "2", Status: "SUPERSEDED", Time: now.Add(-time.Hour)},
+		{Name: "foo", Revision: "3", Status: "DEPLOYED", Time: now.Add(-time.Minute)},
+	}
+
+	testCases := []struct {
+		name       string
+		reader     stubReader
+		failing    map[string]bool
+		maxAge     time.Duration
+		registered []string
+		err        string
+	}{
+		{
+			name:       "syncs revisions within max age",
+			reader:     stubReader{events: events},
+			maxAge:     time.Hour * 24,
+			registered: []string{"2", "3"},
+		},
+		{
+			name:       "zero max age syncs all revisions",
+			reader:     stubReader{events: events},
+			registered: []string{"1", "2", "3"},
+		},
+		{
+			name:       "failed revisions do not stop others",
+			reader:     stubReader{events: events},
+			failing:    map[string]bool{"2": true},
+			registered: []string{"1", "3"},
+			err:        "register revision 2: grafana is down",
+		},
+		{
+			name:       "revisions that can be read are synced",
+			reader:     stubReader{events: events[2:], err: errors.New("decode revision 2")},
+			registered: []string{"3"},
+			err:        "decode revision 2",
+		},
+		{
+			name:   "read error",
+			reader: stubReader{err: errors.New("forbidden")},
+			err:    "forbidden",
+		},
+		{
+			name: "release not found",
+			err:  "release not found",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chronicle := &stubChronicle{failing: tc.failing}
+
+			err := syncRelease(context.Background(), tc.reader, chronicle, "foo", tc.maxAge)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+			assert.Equal(t, tc.registered, chronicle.registered)
+		})
+	}
+}
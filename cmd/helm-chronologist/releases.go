@@ -0,0 +1,109 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/helm"
+	"github.com/hypnoglow/chronologist/internal/kube"
+	"github.com/hypnoglow/chronologist/internal/problems"
+)
+
+// Storage backends of Tiller.
+const (
+	storageConfigMap = "configmap"
+	storageSecret    = "secret"
+)
+
+// newReleaseReader returns a reader of releases stored by Tiller in the
+// cluster of the kubeconfig set in the config.
+func newReleaseReader(conf Config) (releaseReader, error) {
+	if conf.Storage != storageConfigMap && conf.Storage != storageSecret {
+		return nil, errors.Errorf("unknown storage %q, must be either %q or %q", conf.Storage, storageConfigMap, storageSecret)
+	}
+
+	_, kubeClient, err := kube.NewConfigAndClient(conf.KubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "create kubernetes client")
+	}
+
+	return &storage{
+		kubernetes: kubeClient,
+		namespace:  conf.TillerNamespace,
+		backend:    conf.Storage,
+	}, nil
+}
+
+// storage reads releases from configmaps or secrets Tiller stores them in.
+type storage struct {
+	kubernetes kubernetes.Interface
+	namespace  string
+	backend    string
+}
+
+// ReleaseEvents returns release events of all revisions of the release,
+// oldest first.
+func (s *storage) ReleaseEvents(name string) ([]chronologist.ReleaseEvent, error) {
+	var events []chronologist.ReleaseEvent
+	var errs []error
+
+	add := func(objectName, data string, labels map[string]string) {
+		re, err := helm.EventFromRawRelease(data)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "create a release event from %s %s/%s", s.backend, s.namespace, objectName))
+			return
+		}
+		re.Labels = labels
+		events = append(events, re)
+	}
+
+	opts := meta_v1.ListOptions{
+		LabelSelector: "OWNER=TILLER,NAME=" + name,
+	}
+	switch s.backend {
+	case storageConfigMap:
+		list, err := s.kubernetes.CoreV1().ConfigMaps(s.namespace).List(opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "list configmaps")
+		}
+		for _, cm := range list.Items {
+			add(cm.Name, cm.Data["release"], cm.Labels)
+		}
+	case storageSecret:
+		list, err := s.kubernetes.CoreV1().Secrets(s.namespace).List(opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "list secrets")
+		}
+		for _, sec := range list.Items {
+			add(sec.Name, string(sec.Data["release"]), sec.Labels)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		a, _ := strconv.Atoi(events[i].Revision)
+		b, _ := strconv.Atoi(events[j].Revision)
+		return a < b
+	})
+	return events, problems.NewAggregate(errs)
+}
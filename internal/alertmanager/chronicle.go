@@ -0,0 +1,245 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertmanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// createdBy is the author of silences created by Chronologist.
+const createdBy = "chronologist"
+
+// Options represent Alertmanager chronicle options.
+type Options struct {
+	// Cluster is the name of the cluster. It distinguishes silences created
+	// by Chronologist instances in different clusters sharing Alertmanager.
+	Cluster string
+
+	// Duration is the maximum duration of the rollout window, counted from
+	// the time the release became pending. Defaults to 30 minutes.
+	Duration time.Duration
+
+	// NamespaceLabel is the alert label matched against the release
+	// namespace. Defaults to "namespace".
+	NamespaceLabel string
+
+	// ReleaseLabel is the alert label matched against the release name.
+	// Defaults to "release".
+	ReleaseLabel string
+
+	// Matchers are additional label values silenced alerts must have,
+	// e.g. cluster name.
+	Matchers map[string]string
+}
+
+// NewChronicle returns a new Alertmanager chronicle.
+func NewChronicle(alertmanager Silencer, log *zap.Logger, opts Options) *Chronicle {
+	if opts.Duration == 0 {
+		opts.Duration = time.Minute * 30
+	}
+	if opts.NamespaceLabel == "" {
+		opts.NamespaceLabel = "namespace"
+	}
+	if opts.ReleaseLabel == "" {
+		opts.ReleaseLabel = "release"
+	}
+
+	return &Chronicle{
+		alertmanager: alertmanager,
+		log:          log,
+		opts:         opts,
+		silences:     make(map[string]string),
+	}
+}
+
+// A Chronicle silences alerts of releases during rollout windows.
+//
+// When a release revision becomes pending, a silence matching alerts of the
+// release is created, ending after the configured duration at the latest.
+// The silence is expired as soon as the release revision is not pending
+// anymore, e.g. reaches DEPLOYED, or is removed.
+type Chronicle struct {
+	alertmanager Silencer
+	log          *zap.Logger
+	opts         Options
+
+	mx sync.Mutex
+	// silences holds silence IDs of release revisions. An empty ID means
+	// the release revision is known to have no active silence.
+	silences map[string]string
+}
+
+// Register adds the release event to the chronicle, creating or expiring
+// the silence of the release revision depending on the release status.
+func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	if !strings.HasPrefix(re.Status, "PENDING") {
+		return c.expire(ctx, re.Name, re.Revision)
+	}
+
+	log := zaplog.Grasp(ctx, c.log)
+
+	key := re.Name + "/" + re.Revision
+
+	c.mx.Lock()
+	id := c.silences[key]
+	c.mx.Unlock()
+
+	if id != "" {
+		log.Debug("Release revision is already silenced, skip")
+		return nil
+	}
+
+	endsAt := re.Time.Add(c.opts.Duration)
+	if !endsAt.After(time.Now()) {
+		log.Debug("Rollout window of the release revision has passed, skip")
+		return nil
+	}
+
+	existing, err := c.findSilence(ctx, re.Name, re.Revision)
+	if err != nil {
+		return err
+	}
+	if existing != "" {
+		log.Debug("Alertmanager silence for the release revision exists, sync is not required")
+		c.remember(key, existing)
+		return nil
+	}
+
+	log.Sugar().Debugf("Creating Alertmanager silence until %s", endsAt.Format(time.RFC3339))
+	id, err = c.alertmanager.CreateSilence(ctx, Silence{
+		Matchers:  c.matchers(re),
+		StartsAt:  re.Time,
+		EndsAt:    endsAt,
+		CreatedBy: createdBy,
+		Comment: fmt.Sprintf(
+			"Release %s revision %s is being rolled out in namespace %s. %s",
+			re.Name, re.Revision, re.Namespace, c.marker(re.Name, re.Revision),
+		),
+	})
+	if err != nil {
+		return errors.Wrap(err, "create silence in alertmanager")
+	}
+
+	c.remember(key, id)
+	return nil
+}
+
+// Unregister removes the release event from the chronicle, expiring the
+// silence of the release revision if it is active.
+func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
+	if err := c.expire(ctx, name, revision); err != nil {
+		return err
+	}
+
+	c.mx.Lock()
+	delete(c.silences, name+"/"+revision)
+	c.mx.Unlock()
+	return nil
+}
+
+func (c *Chronicle) expire(ctx context.Context, name, revision string) error {
+	key := name + "/" + revision
+
+	c.mx.Lock()
+	id, ok := c.silences[key]
+	c.mx.Unlock()
+
+	if ok && id == "" {
+		return nil
+	}
+
+	if !ok {
+		var err error
+		id, err = c.findSilence(ctx, name, revision)
+		if err != nil {
+			return err
+		}
+	}
+
+	if id != "" {
+		zaplog.Grasp(ctx, c.log).Debug("Expiring Alertmanager silence of the release revision")
+		if err := c.alertmanager.ExpireSilence(ctx, id); err != nil {
+			return errors.Wrap(err, "expire silence in alertmanager")
+		}
+	}
+
+	c.remember(key, "")
+	return nil
+}
+
+// findSilence returns ID of the silence created for the release revision,
+// or an empty string if there is no such silence or it has already expired.
+func (c *Chronicle) findSilence(ctx context.Context, name, revision string) (string, error) {
+	ss, err := c.alertmanager.ListSilences(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "list silences in alertmanager")
+	}
+
+	marker := c.marker(name, revision)
+	for _, s := range ss {
+		if s.CreatedBy == createdBy && s.State != StateExpired && strings.HasSuffix(s.Comment, marker) {
+			return s.ID, nil
+		}
+	}
+	return "", nil
+}
+
+func (c *Chronicle) remember(key, id string) {
+	c.mx.Lock()
+	c.silences[key] = id
+	c.mx.Unlock()
+}
+
+func (c *Chronicle) matchers(re chronologist.ReleaseEvent) []Matcher {
+	matchers := []Matcher{
+		{Name: c.opts.NamespaceLabel, Value: re.Namespace},
+		{Name: c.opts.ReleaseLabel, Value: re.Name},
+	}
+
+	names := make([]string, 0, len(c.opts.Matchers))
+	for name := range c.opts.Matchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		matchers = append(matchers, Matcher{Name: name, Value: c.opts.Matchers[name]})
+	}
+
+	return matchers
+}
+
+// marker identifies the silence of the release revision. It is appended to
+// the silence comment, because Alertmanager silences have no other place
+// for arbitrary metadata. Unregister does not know the release namespace,
+// so the marker relies on release names being unique in the cluster.
+func (c *Chronicle) marker(name, revision string) string {
+	if c.opts.Cluster != "" {
+		return fmt.Sprintf("(chronologist: %s/%s/%s)", c.opts.Cluster, name, revision)
+	}
+	return fmt.Sprintf("(chronologist: %s/%s)", name, revision)
+}
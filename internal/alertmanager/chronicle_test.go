@@ -0,0 +1,97 @@
+package alertmanager_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/alertmanager"
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+)
+
+// Tests that chronicle silences the pending release once, even after
+// restart, and expires the silence when the release is deployed.
+func TestChronicle_Register(t *testing.T) {
+	type silence struct {
+		alertmanager.Silence
+		Status struct {
+			State string `json:"state"`
+		} `json:"status"`
+	}
+
+	var silences []silence
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/silences":
+			_ = json.NewEncoder(w).Encode(silences)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v2/silences":
+			var s silence
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&s))
+			s.ID = strconv.Itoa(len(silences) + 1)
+			s.Status.State = alertmanager.StateActive
+			silences = append(silences, s)
+			_ = json.NewEncoder(w).Encode(map[string]string{"silenceID": s.ID})
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/v2/silence/"):
+			for i := range silences {
+				if silences[i].ID == strings.TrimPrefix(r.URL.Path, "/api/v2/silence/") {
+					silences[i].Status.State = alertmanager.StateExpired
+				}
+			}
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	defer srv.Close()
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Now().Add(-time.Minute).Truncate(time.Second),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "PENDING_UPGRADE",
+		Name:      "foo",
+		Revision:  "2",
+		Namespace: "default",
+	}
+
+	client := alertmanager.NewClient(srv.URL)
+	opts := alertmanager.Options{
+		Cluster:  "dev",
+		Duration: time.Hour,
+		Matchers: map[string]string{"cluster": "dev"},
+	}
+
+	cr := alertmanager.NewChronicle(client, zap.NewNop(), opts)
+	assert.NoError(t, cr.Register(context.Background(), re))
+	assert.NoError(t, cr.Register(context.Background(), re))
+
+	// A new chronicle, as after restart, finds the created silence.
+	cr = alertmanager.NewChronicle(client, zap.NewNop(), opts)
+	assert.NoError(t, cr.Register(context.Background(), re))
+
+	if !assert.Len(t, silences, 1) {
+		return
+	}
+	assert.Equal(t, []alertmanager.Matcher{
+		{Name: "namespace", Value: "default"},
+		{Name: "release", Value: "foo"},
+		{Name: "cluster", Value: "dev"},
+	}, silences[0].Matchers)
+	assert.True(t, re.Time.Equal(silences[0].StartsAt))
+	assert.True(t, re.Time.Add(time.Hour).Equal(silences[0].EndsAt))
+	assert.Equal(t, "Release foo revision 2 is being rolled out in namespace default. (chronologist: dev/foo/2)", silences[0].Comment)
+
+	re.Status = "DEPLOYED"
+	assert.NoError(t, cr.Register(context.Background(), re))
+	assert.Equal(t, alertmanager.StateExpired, silences[0].Status.State)
+
+	// Unregister of the already expired silence is a no-op.
+	assert.NoError(t, cr.Unregister(context.Background(), "foo", "2"))
+	assert.Len(t, silences, 1)
+}
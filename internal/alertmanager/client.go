@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Silence states.
+const (
+	StateActive  = "active"
+	StatePending = "pending"
+	StateExpired = "expired"
+)
+
+// Matcher represents Alertmanager silence matcher.
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+}
+
+// Silence represents Alertmanager silence.
+type Silence struct {
+	ID        string    `json:"id,omitempty"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+
+	// State is only set for silences returned by Alertmanager.
+	State string `json:"-"`
+}
+
+// Silencer can manage silences.
+type Silencer interface {
+	// CreateSilence creates the silence and returns its ID.
+	CreateSilence(ctx context.Context, s Silence) (string, error)
+
+	// ListSilences returns all silences, including expired ones.
+	ListSilences(ctx context.Context) ([]Silence, error)
+
+	// ExpireSilence expires the silence.
+	ExpireSilence(ctx context.Context, id string) error
+}
+
+// Client is an Alertmanager HTTP API v2 client.
+//
+// Client implements Silencer interface.
+type Client struct {
+	host string
+
+	client *http.Client
+}
+
+// CreateSilence creates a silence.
+//
+// See: https://github.com/prometheus/alertmanager/blob/master/api/v2/openapi.yaml
+func (c *Client) CreateSilence(ctx context.Context, s Silence) (string, error) {
+	var resp struct {
+		SilenceID string `json:"silenceID"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/v2/silences", s, &resp)
+	return resp.SilenceID, err
+}
+
+// ListSilences lists silences.
+//
+// See: https://github.com/prometheus/alertmanager/blob/master/api/v2/openapi.yaml
+func (c *Client) ListSilences(ctx context.Context) ([]Silence, error) {
+	var resp []struct {
+		Silence
+		Status struct {
+			State string `json:"state"`
+		} `json:"status"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v2/silences", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	ss := make([]Silence, 0, len(resp))
+	for _, r := range resp {
+		s := r.Silence
+		s.State = r.Status.State
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+// ExpireSilence expires a silence.
+//
+// See: https://github.com/prometheus/alertmanager/blob/master/api/v2/openapi.yaml
+func (c *Client) ExpireSilence(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v2/silence/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return errors.Wrap(err, "encode request to json")
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.host+path, body)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("got response %s", resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrap(err, "decode response body from json")
+	}
+	return nil
+}
+
+// NewClient returns a new Alertmanager client.
+func NewClient(host string) *Client {
+	return &Client{
+		host:   host,
+		client: http.DefaultClient,
+	}
+}
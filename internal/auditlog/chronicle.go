@@ -0,0 +1,131 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auditlog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// SchemaVersion is the version of the record schema. It is incremented on
+// every incompatible change of the Record.
+const SchemaVersion = 1
+
+const (
+	// ActionRegister is recorded when a release event is registered.
+	ActionRegister = "register"
+
+	// ActionUnregister is recorded when a release event is unregistered.
+	ActionUnregister = "unregister"
+)
+
+// Record represents a single line of the audit log.
+type Record struct {
+	SchemaVersion int       `json:"schema_version"`
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"`
+	Cluster       string    `json:"cluster,omitempty"`
+	Release       Release   `json:"release"`
+}
+
+// Release represents the release event of the record. Only Name and Revision
+// are known for unregistered release events.
+type Release struct {
+	Name       string     `json:"name"`
+	Revision   string     `json:"revision"`
+	Namespace  string     `json:"namespace,omitempty"`
+	Type       string     `json:"type,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	DeployedAt *time.Time `json:"deployed_at,omitempty"`
+}
+
+// NewChronicle returns a new audit log chronicle that writes records to w.
+func NewChronicle(w io.Writer, log *zap.Logger, cluster string) *Chronicle {
+	return &Chronicle{
+		w:       w,
+		log:     log,
+		cluster: cluster,
+		now:     time.Now,
+	}
+}
+
+// A Chronicle records every release event it processes to the audit log,
+// one JSON object per line.
+type Chronicle struct {
+	w       io.Writer
+	log     *zap.Logger
+	cluster string
+
+	// now is used to timestamp records, replaced in tests.
+	now func() time.Time
+}
+
+// Register adds a record of the release event registration to the audit log.
+func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	deployedAt := re.Time
+	return c.write(ctx, Record{
+		Action: ActionRegister,
+		Release: Release{
+			Name:       re.Name,
+			Revision:   re.Revision,
+			Namespace:  re.Namespace,
+			Type:       re.Type.String(),
+			Status:     re.Status,
+			DeployedAt: &deployedAt,
+		},
+	})
+}
+
+// Unregister adds a record of the release event removal to the audit log.
+func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
+	return c.write(ctx, Record{
+		Action: ActionUnregister,
+		Release: Release{
+			Name:     name,
+			Revision: revision,
+		},
+	})
+}
+
+func (c *Chronicle) write(ctx context.Context, rec Record) error {
+	rec.SchemaVersion = SchemaVersion
+	rec.Time = c.now().UTC()
+	rec.Cluster = c.cluster
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "encode record to json")
+	}
+	b = append(b, '\n')
+
+	// The record is written with a single call, so that concurrent writes
+	// and rotation never split it.
+	if _, err = c.w.Write(b); err != nil {
+		return errors.Wrap(err, "write record to audit log")
+	}
+
+	zaplog.Grasp(ctx, c.log).Sugar().Debugf("Recorded %s action to the audit log", rec.Action)
+	return nil
+}
@@ -0,0 +1,81 @@
+package auditlog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/auditlog"
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+)
+
+// Tests that chronicle writes one record per line in the documented schema.
+// Changing the expected records here means changing the schema, which
+// requires incrementing auditlog.SchemaVersion.
+func TestChronicle(t *testing.T) {
+	var buf bytes.Buffer
+	cr := auditlog.NewChronicle(&buf, zap.NewNop(), "dev")
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	before := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, cr.Register(context.Background(), re))
+	require.NoError(t, cr.Unregister(context.Background(), "foo", "1"))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	// Records are timestamped when written, so the time is checked apart
+	// from the rest of the record.
+	stripTime := func(line string) string {
+		var rec map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+
+		ts, err := time.Parse(time.RFC3339Nano, rec["time"].(string))
+		require.NoError(t, err)
+		assert.False(t, ts.Before(before))
+		delete(rec, "time")
+
+		b, err := json.Marshal(rec)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	assert.Equal(t, 1, auditlog.SchemaVersion)
+	assert.JSONEq(t, `{
+		"schema_version": 1,
+		"action": "register",
+		"cluster": "dev",
+		"release": {
+			"name": "foo",
+			"revision": "1",
+			"namespace": "default",
+			"type": "rollout",
+			"status": "DEPLOYED",
+			"deployed_at": "2019-01-02T15:04:05Z"
+		}
+	}`, stripTime(lines[0]))
+	assert.JSONEq(t, `{
+		"schema_version": 1,
+		"action": "unregister",
+		"cluster": "dev",
+		"release": {
+			"name": "foo",
+			"revision": "1"
+		}
+	}`, stripTime(lines[1]))
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auditlog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// segmentTimeLayout is used to name rotated segments. It sorts
+// lexicographically in chronological order.
+const segmentTimeLayout = "2006-01-02T15-04-05.000"
+
+// FileOptions represent rotating file options.
+type FileOptions struct {
+	// MaxSize is the size in bytes after which the file is rotated.
+	// Zero disables rotation.
+	MaxSize int64
+
+	// MaxSegments is the number of rotated segments to keep.
+	// Zero keeps all of them.
+	MaxSegments int
+
+	// Compress enables gzip compression of rotated segments.
+	Compress bool
+}
+
+// RotatingFile is an append-only file which is rotated by size.
+//
+// Rotated segments are named after the file with the rotation time appended,
+// e.g. "audit.jsonl.2019-01-02T15-04-05.000", with ".gz" suffix when
+// compressed.
+type RotatingFile struct {
+	path string
+	opts FileOptions
+
+	mx   sync.Mutex
+	file *os.File
+	size int64
+
+	// now is used to name segments, replaced in tests.
+	now func() time.Time
+}
+
+// OpenRotatingFile opens the file for appending, creating it if necessary.
+func OpenRotatingFile(path string, opts FileOptions) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path: path,
+		opts: opts,
+		now:  time.Now,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p to the file. The file is rotated beforehand if p does not
+// fit into it, so a single write is never split across segments.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if f.file == nil {
+		return 0, errors.New("file is closed")
+	}
+
+	if f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, errors.Wrap(err, "rotate file")
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return errors.Wrap(err, "create directory")
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "stat file")
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return errors.Wrap(err, "close file")
+	}
+	f.file = nil
+
+	ts := f.now().UTC().Format(segmentTimeLayout)
+	segment := f.path + "." + ts
+	// Do not overwrite segments rotated within the same millisecond.
+	for i := 1; exists(segment) || exists(segment+".gz"); i++ {
+		segment = fmt.Sprintf("%s.%s-%d", f.path, ts, i)
+	}
+	if err := os.Rename(f.path, segment); err != nil {
+		return errors.Wrap(err, "rename file")
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.opts.Compress {
+		if err := compress(segment); err != nil {
+			return errors.Wrap(err, "compress segment")
+		}
+	}
+
+	return f.removeOldSegments()
+}
+
+func (f *RotatingFile) removeOldSegments() error {
+	if f.opts.MaxSegments <= 0 {
+		return nil
+	}
+
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return errors.Wrap(err, "list segments")
+	}
+
+	var segments []string
+	for _, m := range matches {
+		// Skip temporary files of interrupted compression.
+		if !strings.HasSuffix(m, ".tmp") {
+			segments = append(segments, m)
+		}
+	}
+	// Compare names without ".gz" suffix, so segments rotated within the same
+	// millisecond keep their order.
+	sort.Slice(segments, func(i, j int) bool {
+		return strings.TrimSuffix(segments[i], ".gz") < strings.TrimSuffix(segments[j], ".gz")
+	})
+
+	for len(segments) > f.opts.MaxSegments {
+		if err := os.Remove(segments[0]); err != nil {
+			return errors.Wrap(err, "remove old segment")
+		}
+		segments = segments[1:]
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compress gzips the file, replacing it with a file with ".gz" suffix.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,78 @@
+package auditlog_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hypnoglow/chronologist/internal/auditlog"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+
+	f, err := auditlog.OpenRotatingFile(path, auditlog.FileOptions{
+		MaxSize:     10,
+		MaxSegments: 2,
+		Compress:    true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = f.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "fourth\n", string(b))
+
+	segments, err := filepath.Glob(path + ".*")
+	assert.NoError(t, err)
+	sort.Slice(segments, func(i, j int) bool {
+		return strings.TrimSuffix(segments[i], ".gz") < strings.TrimSuffix(segments[j], ".gz")
+	})
+	if !assert.Len(t, segments, 2) {
+		return
+	}
+
+	// The oldest segment ("first") was removed, the remaining ones are
+	// compressed and keep whole lines.
+	for i, expected := range []string{"second\n", "third\n"} {
+		assert.Equal(t, ".gz", filepath.Ext(segments[i]))
+		assert.Equal(t, expected, readGzip(t, segments[i]))
+	}
+}
+
+func readGzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return string(b)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chronologist
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/chronologist/internal/problems"
+)
+
+// temporary is implemented by errors that tell whether retrying may help,
+// e.g. grafana.APIError.
+type temporary interface {
+	Temporary() bool
+}
+
+// retryAfter is implemented by errors that tell when retrying may help,
+// e.g. when the circuit breaker of grafana.Client is open.
+type retryAfter interface {
+	RetryAfter() time.Duration
+}
+
+// causer is implemented by errors wrapped with github.com/pkg/errors.
+type causer interface {
+	Cause() error
+}
+
+// RetryAfter returns how long to wait before retrying a chronicle call that
+// failed with the error, or zero if the error does not tell. For aggregated
+// errors, the longest wait is returned.
+func RetryAfter(err error) time.Duration {
+	for err != nil {
+		if agg, ok := err.(problems.Aggregate); ok {
+			var max time.Duration
+			for _, e := range agg.Errors() {
+				if d := RetryAfter(e); d > max {
+					max = d
+				}
+			}
+			return max
+		}
+
+		if r, ok := err.(retryAfter); ok {
+			return r.RetryAfter()
+		}
+
+		c, ok := err.(causer)
+		if !ok {
+			return 0
+		}
+		err = c.Cause()
+	}
+	return 0
+}
+
+// IsRetryable reports whether a chronicle call that failed with the error
+// may succeed if retried. Errors that do not tell are considered retryable.
+// Aggregated errors, e.g. of MultiChronicle, are retryable if any of them is.
+func IsRetryable(err error) bool {
+	err = errors.Cause(err)
+
+	if agg, ok := err.(problems.Aggregate); ok {
+		for _, e := range agg.Errors() {
+			if IsRetryable(e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if t, ok := err.(temporary); ok {
+		return t.Temporary()
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package chronologist_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/problems"
+)
+
+type temporaryError bool
+
+func (e temporaryError) Error() string   { return "temporary error" }
+func (e temporaryError) Temporary() bool { return bool(e) }
+
+type retryAfterError time.Duration
+
+func (e retryAfterError) Error() string             { return "retry after error" }
+func (e retryAfterError) RetryAfter() time.Duration { return time.Duration(e) }
+
+func TestIsRetryable(t *testing.T) {
+	testCases := map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"unknown": {
+			err:       errors.New("foo"),
+			retryable: true,
+		},
+		"temporary": {
+			err:       errors.Wrap(temporaryError(true), "foo"),
+			retryable: true,
+		},
+		"permanent": {
+			err:       errors.Wrap(temporaryError(false), "foo"),
+			retryable: false,
+		},
+		"aggregate of permanent": {
+			err:       problems.NewAggregate([]error{temporaryError(false), errors.Wrap(temporaryError(false), "foo")}),
+			retryable: false,
+		},
+		"aggregate with temporary": {
+			err:       problems.NewAggregate([]error{temporaryError(false), temporaryError(true)}),
+			retryable: true,
+		},
+		"wrapped aggregate": {
+			err:       errors.Wrap(problems.NewAggregate([]error{temporaryError(false)}), "foo"),
+			retryable: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.retryable, chronologist.IsRetryable(tc.err))
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected time.Duration
+	}{
+		"unknown": {
+			err:      errors.New("foo"),
+			expected: 0,
+		},
+		"wrapped": {
+			err:      errors.Wrap(errors.Wrap(retryAfterError(time.Minute), "foo"), "bar"),
+			expected: time.Minute,
+		},
+		"aggregate takes the longest": {
+			err: problems.NewAggregate([]error{
+				errors.New("foo"),
+				retryAfterError(time.Minute),
+				errors.Wrap(retryAfterError(time.Hour), "bar"),
+			}),
+			expected: time.Hour,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, chronologist.RetryAfter(tc.err))
+		})
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chronologist
+
+import (
+	"context"
+
+	"github.com/hypnoglow/chronologist/internal/problems"
+)
+
+// MultiChronicle is a chronicle that fans out release events to
+// multiple chronicles.
+type MultiChronicle []Chronicle
+
+// Register registers the release event in every chronicle. It does not stop
+// on the first failure, so one broken sink does not affect the others.
+func (m MultiChronicle) Register(ctx context.Context, re ReleaseEvent) error {
+	var errs []error
+	for _, c := range m {
+		if err := c.Register(ctx, re); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return problems.NewAggregate(errs)
+}
+
+// Unregister unregisters the release event from every chronicle.
+func (m MultiChronicle) Unregister(ctx context.Context, name, revision string) error {
+	var errs []error
+	for _, c := range m {
+		if err := c.Unregister(ctx, name, revision); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return problems.NewAggregate(errs)
+}
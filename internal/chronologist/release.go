@@ -20,7 +20,10 @@ package chronologist
 
 import (
 	"context"
+	"fmt"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-test/deep"
 )
@@ -54,10 +57,70 @@ type ReleaseEvent struct {
 	Name      string
 	Revision  string
 	Namespace string
+
+	// Chart describes the chart the release was deployed from. Not every
+	// source is able to provide it, so it is not taken into account
+	// by Differences.
+	Chart Chart
+
+	// Labels are labels of the Kubernetes object helm stores the release
+	// in. Like Chart, they are not taken into account by Differences.
+	Labels map[string]string
+}
+
+// Chart represents metadata of a chart.
+type Chart struct {
+	Name        string
+	Version     string
+	AppVersion  string
+	Annotations map[string]string
+}
+
+// Summary returns a short human-readable description of the release event.
+func (r ReleaseEvent) Summary() string {
+	return fmt.Sprintf("%s release %s", capitalize(r.Type.String()), r.Name)
+}
+
+// capitalize returns the string with the first letter in upper case.
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// Tag is a key-value pair describing a release event.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// String returns the tag in "key=value" form.
+func (t Tag) String() string {
+	return t.Key + "=" + t.Value
+}
+
+// Tags returns tags describing the release event. Sinks attach these tags
+// to release events, so they can be found and filtered consistently across
+// different sinks.
+func (r ReleaseEvent) Tags() []Tag {
+	return []Tag{
+		{Key: "event", Value: "release"},
+		{Key: "heritage", Value: "chronologist"},
+		{Key: "release_type", Value: r.Type.String()},
+		{Key: "release_status", Value: r.Status},
+		{Key: "release_name", Value: r.Name},
+		{Key: "release_revision", Value: r.Revision},
+		{Key: "release_namespace", Value: r.Namespace},
+	}
 }
 
 // Differences compares release events and returns differences.
+// Chart metadata and labels are not compared.
 func (r ReleaseEvent) Differences(r2 ReleaseEvent) []string {
+	r.Chart, r2.Chart = Chart{}, Chart{}
+	r.Labels, r2.Labels = nil, nil
 	return deep.Equal(r, r2)
 }
 
@@ -67,3 +130,10 @@ type Chronicle interface {
 	Register(ctx context.Context, re ReleaseEvent) error
 	Unregister(ctx context.Context, name, revision string) error
 }
+
+// Lister lists release events of all releases that exist. When some of the
+// releases cannot be read, it returns release events of the others along
+// with an error.
+type Lister interface {
+	ListReleaseEvents(ctx context.Context) ([]ReleaseEvent, error)
+}
@@ -0,0 +1,25 @@
+package chronologist_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+)
+
+func TestReleaseEvent_Summary(t *testing.T) {
+	testCases := []struct {
+		typ      chronologist.ReleaseType
+		expected string
+	}{
+		{typ: chronologist.ReleaseTypeRollout, expected: "Rollout release foo"},
+		{typ: chronologist.ReleaseTypeRollback, expected: "Rollback release foo"},
+		{typ: "", expected: " release foo"},
+	}
+
+	for _, tc := range testCases {
+		re := chronologist.ReleaseEvent{Type: tc.typ, Name: "foo"}
+		assert.Equal(t, tc.expected, re.Summary())
+	}
+}
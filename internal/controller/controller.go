@@ -148,6 +148,23 @@ func (c *Controller) processNextItem() bool {
 		return true
 	}
 
+	if !chronologist.IsRetryable(err) {
+		// Retrying will not help, e.g. when credentials are rejected. The key
+		// is synced again on the next resync.
+		utilruntime.HandleError(fmt.Errorf("error processing %s (not retryable, giving up): %v", key, err))
+		c.queue.Forget(key)
+		return true
+	}
+
+	if wait := chronologist.RetryAfter(err); wait > 0 {
+		// Retrying earlier will not help, e.g. when the circuit breaker of
+		// Grafana is open, so the key is requeued after the wait without
+		// counting it as a retry.
+		utilruntime.HandleError(fmt.Errorf("error processing %s (will retry in %s): %v", key, wait, err))
+		c.queue.AddAfter(key, wait)
+		return true
+	}
+
 	if c.queue.NumRequeues(key) < maxRetries {
 		utilruntime.HandleError(fmt.Errorf("error processing %s (will retry): %v", key, err))
 		c.queue.AddRateLimited(key)
@@ -0,0 +1,145 @@
+package controller_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/hypnoglow/chronologist/internal/controller"
+	"github.com/hypnoglow/chronologist/internal/grafana"
+	"github.com/hypnoglow/chronologist/internal/grafana/fake"
+)
+
+const tillerNamespace = "kube-system"
+
+// Tests that the controller annotates release revisions Tiller stores in
+// configmaps, updates annotations when revisions change and deletes them
+// when revisions are purged.
+func TestController_configMaps(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+	chronicle := grafana.NewChronicle(client, zap.NewNop(), grafana.Options{})
+
+	kube := k8sfake.NewSimpleClientset(
+		releaseConfigMap(t, "foo", 1, rspb.Status_DEPLOYED),
+		// Not stored by Tiller, ignored.
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "bar.v1", Namespace: tillerNamespace}},
+	)
+
+	c, err := controller.New(zap.NewNop(), kube, chronicle, controller.Options{
+		MaxAge:          time.Hour,
+		WatchConfigMaps: true,
+	})
+	require.NoError(t, err)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.Run(stopCh)
+		close(done)
+	}()
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+
+	waitForReleases(t, srv, "foo/1/DEPLOYED")
+
+	// Upgrade.
+	_, err = kube.CoreV1().ConfigMaps(tillerNamespace).Update(releaseConfigMap(t, "foo", 1, rspb.Status_SUPERSEDED))
+	require.NoError(t, err)
+	_, err = kube.CoreV1().ConfigMaps(tillerNamespace).Create(releaseConfigMap(t, "foo", 2, rspb.Status_DEPLOYED))
+	require.NoError(t, err)
+
+	waitForReleases(t, srv, "foo/1/SUPERSEDED", "foo/2/DEPLOYED")
+
+	// Purge.
+	for _, name := range []string{"foo.v1", "foo.v2"} {
+		err = kube.CoreV1().ConfigMaps(tillerNamespace).Delete(name, &meta_v1.DeleteOptions{})
+		require.NoError(t, err)
+	}
+
+	waitForReleases(t, srv)
+}
+
+// waitForReleases waits until annotations in Grafana describe exactly the
+// release revisions, as "<name>/<revision>/<status>".
+func waitForReleases(t *testing.T, srv *fake.Server, expected ...string) {
+	t.Helper()
+
+	sort.Strings(expected)
+
+	var actual []string
+	deadline := time.Now().Add(time.Second * 10)
+	for time.Now().Before(deadline) {
+		actual = actual[:0]
+		for _, a := range srv.Annotations() {
+			re := a.ToReleaseEvent()
+			actual = append(actual, fmt.Sprintf("%s/%s/%s", re.Name, re.Revision, re.Status))
+		}
+		sort.Strings(actual)
+		if fmt.Sprint(actual) == fmt.Sprint(expected) {
+			return
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	t.Fatalf("Expected annotations of %v, got %v", expected, actual)
+}
+
+// releaseConfigMap returns the configmap Tiller stores the release revision
+// in, encoding the release the same way Tiller does.
+func releaseConfigMap(t *testing.T, name string, version int32, status rspb.Status_Code) *core_v1.ConfigMap {
+	deployed, err := ptypes.TimestampProto(time.Now())
+	require.NoError(t, err)
+
+	b, err := proto.Marshal(&rspb.Release{
+		Name:      name,
+		Version:   version,
+		Namespace: "default",
+		Info: &rspb.Info{
+			Status:       &rspb.Status{Code: status},
+			LastDeployed: deployed,
+			Description:  "Upgrade complete",
+		},
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return &core_v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:              fmt.Sprintf("%s.v%d", name, version),
+			Namespace:         tillerNamespace,
+			CreationTimestamp: meta_v1.Now(),
+			Labels: map[string]string{
+				"NAME":    name,
+				"OWNER":   "TILLER",
+				"STATUS":  status.String(),
+				"VERSION": fmt.Sprint(version),
+			},
+		},
+		Data: map[string]string{
+			"release": base64.StdEncoding.EncodeToString(buf.Bytes()),
+		},
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/helm"
+	"github.com/hypnoglow/chronologist/internal/problems"
+)
+
+// listPageSize is the number of configmaps (or secrets) requested at once.
+const listPageSize = 100
+
+// Lister lists release events of all releases stored in configmaps
+// (or secrets), regardless of their age.
+type Lister struct {
+	kubernetes kubernetes.Interface
+	backend    releaseBackend
+}
+
+// NewLister returns a new lister of releases stored in configmaps or secrets,
+// depending on opts. MaxAge is ignored.
+func NewLister(kubernetes kubernetes.Interface, opts Options) (*Lister, error) {
+	if opts.WatchConfigMaps == opts.WatchSecrets {
+		return nil, fmt.Errorf("incorrect configuration: need to list either configmaps or secrets")
+	}
+
+	l := &Lister{
+		kubernetes: kubernetes,
+		backend:    backendSecrets,
+	}
+	if opts.WatchConfigMaps {
+		l.backend = backendConfigMaps
+	}
+	return l, nil
+}
+
+// ListReleaseEvents implements chronologist.Lister.
+func (l *Lister) ListReleaseEvents(ctx context.Context) ([]chronologist.ReleaseEvent, error) {
+	var events []chronologist.ReleaseEvent
+	var errs []error
+
+	add := func(namespace, name, data string, labels map[string]string) {
+		re, err := helm.EventFromRawRelease(data)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "create a release event from %s %s/%s", l.backend, namespace, name))
+			return
+		}
+		re.Labels = labels
+		events = append(events, re)
+	}
+
+	opts := meta_v1.ListOptions{
+		LabelSelector: releaseLabelSelector,
+		Limit:         listPageSize,
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var cont string
+		switch l.backend {
+		case backendConfigMaps:
+			list, err := l.kubernetes.CoreV1().ConfigMaps(meta_v1.NamespaceAll).List(opts)
+			if err != nil {
+				return nil, errors.Wrap(err, "list configmaps")
+			}
+			for _, cm := range list.Items {
+				add(cm.Namespace, cm.Name, cm.Data["release"], cm.Labels)
+			}
+			cont = list.Continue
+		case backendSecrets:
+			list, err := l.kubernetes.CoreV1().Secrets(meta_v1.NamespaceAll).List(opts)
+			if err != nil {
+				return nil, errors.Wrap(err, "list secrets")
+			}
+			for _, sec := range list.Items {
+				add(sec.Namespace, sec.Name, string(sec.Data["release"]), sec.Labels)
+			}
+			cont = list.Continue
+		}
+
+		if cont == "" {
+			return events, problems.NewAggregate(errs)
+		}
+		opts.Continue = cont
+	}
+}
@@ -163,6 +163,7 @@ func (c *Controller) syncSecret(key string) error {
 	if err != nil {
 		return errors.Wrap(err, "create a release event from raw helm release data")
 	}
+	re.Labels = sec.Labels
 
 	return c.syncReleaseEvent(ctx, re, name, revision)
 }
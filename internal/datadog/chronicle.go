@@ -0,0 +1,211 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datadog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/lru"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// maxAggregationKeyLength is the limit of aggregation key length in datadog.
+const maxAggregationKeyLength = 100
+
+// postedSize is the number of release revisions the chronicle remembers
+// the posted release events of.
+const postedSize = 10000
+
+// EventFromReleaseEvent assembles a datadog event from the chronologist
+// release event.
+func EventFromReleaseEvent(cluster string, re chronologist.ReleaseEvent) Event {
+	var tags []string
+	for _, tag := range re.Tags() {
+		tags = append(tags, tag.Key+":"+tag.Value)
+	}
+	if cluster != "" {
+		tags = append(tags, "cluster:"+cluster)
+	}
+
+	return Event{
+		Title: re.Summary(),
+		Text: fmt.Sprintf(
+			"Release %s revision %s in namespace %s is %s",
+			re.Name, re.Revision, re.Namespace, re.Status,
+		),
+		DateHappened:   re.Time.Unix(),
+		Tags:           normalizeTags(tags),
+		AggregationKey: aggregationKey(cluster, re.Name),
+		AlertType:      alertType(re.Status),
+		SourceTypeName: "helm",
+	}
+}
+
+// aggregationKey returns the key datadog uses to group events of all
+// revisions of the release. Helm release names are unique in the cluster.
+func aggregationKey(cluster, name string) string {
+	key := "chronologist:" + name
+	if cluster != "" {
+		key = "chronologist:" + cluster + ":" + name
+	}
+	if len(key) > maxAggregationKeyLength {
+		key = key[:maxAggregationKeyLength]
+	}
+	return key
+}
+
+// normalizeTags returns tags the way datadog stores them, which is in lower
+// case, so tags of posted events can be compared with the ones datadog
+// returns.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, len(tags))
+	for i, tag := range tags {
+		normalized[i] = strings.ToLower(tag)
+	}
+	return normalized
+}
+
+func alertType(status string) string {
+	switch {
+	case status == "DEPLOYED" || status == "SUPERSEDED":
+		return "success"
+	case status == "FAILED":
+		return "error"
+	case strings.HasPrefix(status, "PENDING"):
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// Options represent datadog chronicle options.
+type Options struct {
+	// Cluster is the name of the cluster, added to event tags.
+	Cluster string
+
+	// QueryEvents enables looking up existing events before posting a new
+	// one, so resyncs do not produce duplicate events even after restarts.
+	// It requires the application key. Without it, release events within
+	// the max age of release revisions are posted again on every restart.
+	QueryEvents bool
+}
+
+// NewChronicle returns a new datadog chronicle.
+func NewChronicle(datadog EventStore, log *zap.Logger, opts Options) *Chronicle {
+	return &Chronicle{
+		datadog: datadog,
+		log:     log,
+		opts:    opts,
+		posted:  lru.New(postedSize),
+	}
+}
+
+// A Chronicle registers release events in datadog as events.
+//
+// Datadog events can neither be updated nor deleted, so a new event is posted
+// each time the release event changes, and an event about the removal is
+// posted when the release event is unregistered. All events of the release
+// share the aggregation key, so datadog groups them together.
+type Chronicle struct {
+	datadog EventStore
+	log     *zap.Logger
+	opts    Options
+
+	// posted holds the last release event posted for each release revision.
+	posted *lru.Cache
+}
+
+// Register adds the release event to the chronicle, posting a datadog event
+// unless the same event has already been posted.
+func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	log := zaplog.Grasp(ctx, c.log)
+
+	key := re.Name + "/" + re.Revision
+
+	prev, ok := c.posted.Get(key)
+	if ok && len(re.Differences(prev.(chronologist.ReleaseEvent))) == 0 {
+		log.Debug("Release event has already been posted to Datadog, skip")
+		return nil
+	}
+
+	ev := EventFromReleaseEvent(c.opts.Cluster, re)
+
+	if c.opts.QueryEvents && !ok {
+		existing, err := c.datadog.QueryEvents(
+			ctx,
+			normalizeTags([]string{"heritage:chronologist", "release_name:" + re.Name, "release_revision:" + re.Revision}),
+			re.Time.Add(-time.Second),
+			re.Time.Add(time.Second),
+		)
+		if err != nil {
+			return errors.Wrap(err, "query events in datadog")
+		}
+
+		for _, e := range existing {
+			if chronologist.SameTags(normalizeTags(e.Tags), ev.Tags) {
+				log.Debug("Datadog event correctly reflects the release event, sync is not required")
+				c.posted.Add(key, re)
+				return nil
+			}
+		}
+	}
+
+	log.Debug("Posting Datadog event for the release event")
+	if err := c.datadog.PostEvent(ctx, ev); err != nil {
+		return errors.Wrap(err, "post event to datadog")
+	}
+
+	c.posted.Add(key, re)
+	return nil
+}
+
+// Unregister removes the release event from the chronicle. Datadog events
+// cannot be deleted, so this posts an event about the removal instead.
+func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
+	c.posted.Remove(name + "/" + revision)
+
+	tags := []string{
+		"event:release",
+		"heritage:chronologist",
+		"release_action:unregister",
+		"release_name:" + name,
+		"release_revision:" + revision,
+	}
+	if c.opts.Cluster != "" {
+		tags = append(tags, "cluster:"+c.opts.Cluster)
+	}
+
+	zaplog.Grasp(ctx, c.log).Debug("Posting Datadog event for the release removal")
+
+	err := c.datadog.PostEvent(ctx, Event{
+		Title:          fmt.Sprintf("Removed release %s", name),
+		Text:           fmt.Sprintf("Release %s revision %s was removed", name, revision),
+		DateHappened:   time.Now().Unix(),
+		Tags:           normalizeTags(tags),
+		AggregationKey: aggregationKey(c.opts.Cluster, name),
+		AlertType:      "info",
+		SourceTypeName: "helm",
+	})
+	return errors.Wrap(err, "post event to datadog")
+}
@@ -0,0 +1,73 @@
+package datadog_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/datadog"
+)
+
+// Tests that chronicle posts the release event once and skips it on resync.
+func TestChronicle_Register(t *testing.T) {
+	var posted []datadog.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "key", r.Header.Get("DD-API-KEY"))
+
+		switch r.Method {
+		case http.MethodGet:
+			assert.Equal(t, "/api/v1/events", r.URL.Path)
+			assert.Equal(t, "heritage:chronologist,release_name:foo,release_revision:1", r.URL.Query().Get("tags"))
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"events": posted})
+		case http.MethodPost:
+			var ev datadog.Event
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&ev))
+			// Datadog stores tags in lower case.
+			for i, tag := range ev.Tags {
+				ev.Tags[i] = strings.ToLower(tag)
+			}
+			posted = append(posted, ev)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	client := datadog.NewClient(srv.URL, "key", "app")
+
+	cr := datadog.NewChronicle(client, zap.NewNop(), datadog.Options{Cluster: "dev", QueryEvents: true})
+	assert.NoError(t, cr.Register(context.Background(), re))
+
+	// A new chronicle, as after restart, finds the posted event.
+	cr = datadog.NewChronicle(client, zap.NewNop(), datadog.Options{Cluster: "dev", QueryEvents: true})
+	assert.NoError(t, cr.Register(context.Background(), re))
+
+	if !assert.Len(t, posted, 1) {
+		return
+	}
+	assert.Equal(t, datadog.Event{
+		Title:          "Rollout release foo",
+		Text:           "Release foo revision 1 in namespace default is DEPLOYED",
+		DateHappened:   1546441445,
+		Tags:           []string{"event:release", "heritage:chronologist", "release_type:rollout", "release_status:deployed", "release_name:foo", "release_revision:1", "release_namespace:default", "cluster:dev"},
+		AggregationKey: "chronologist:dev:foo",
+		AlertType:      "success",
+		SourceTypeName: "helm",
+	}, posted[0])
+}
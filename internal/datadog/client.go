@@ -0,0 +1,158 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultHost is the address of Datadog API in the US1 site.
+const DefaultHost = "https://api.datadoghq.com"
+
+// Event represents datadog event.
+type Event struct {
+	ID             int64    `json:"id,omitempty"`
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	DateHappened   int64    `json:"date_happened"`
+	Tags           []string `json:"tags"`
+	AggregationKey string   `json:"aggregation_key,omitempty"`
+	AlertType      string   `json:"alert_type,omitempty"`
+	SourceTypeName string   `json:"source_type_name,omitempty"`
+}
+
+// Events is a set of datadog events.
+type Events []Event
+
+// EventStore can manage events.
+type EventStore interface {
+	// PostEvent posts the event.
+	PostEvent(ctx context.Context, event Event) error
+
+	// QueryEvents returns events having all of the tags within the time range.
+	QueryEvents(ctx context.Context, tags []string, start, end time.Time) (Events, error)
+}
+
+// Client is a datadog HTTP API client.
+//
+// Client implements EventStore interface using datadog events API.
+type Client struct {
+	host   string
+	apiKey string
+	appKey string
+
+	client *http.Client
+}
+
+// PostEvent posts the event to datadog.
+//
+// See: https://docs.datadoghq.com/api/latest/events/#post-an-event
+func (c *Client) PostEvent(ctx context.Context, event Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "encode request to json")
+	}
+
+	u := fmt.Sprintf("%s/api/v1/events", c.host)
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req = c.enrichRequest(ctx, req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return errors.Errorf("got response %s", resp.Status)
+	}
+
+	return nil
+}
+
+// QueryEvents fetches events from datadog. It requires the application key.
+//
+// See: https://docs.datadoghq.com/api/latest/events/#get-a-list-of-events
+func (c *Client) QueryEvents(ctx context.Context, tags []string, start, end time.Time) (Events, error) {
+	query := url.Values{}
+	query.Set("start", strconv.FormatInt(start.Unix(), 10))
+	query.Set("end", strconv.FormatInt(end.Unix(), 10))
+	query.Set("tags", strings.Join(tags, ","))
+
+	u := fmt.Sprintf("%s/api/v1/events?%s", c.host, query.Encode())
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	req = c.enrichRequest(ctx, req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("got response %s", resp.Status)
+	}
+
+	var body struct {
+		Events Events `json:"events"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "decode response body from json")
+	}
+
+	return body.Events, nil
+}
+
+func (c *Client) enrichRequest(ctx context.Context, req *http.Request) *http.Request {
+	req = req.WithContext(ctx)
+	req.Header.Set("DD-API-KEY", c.apiKey)
+	if c.appKey != "" {
+		req.Header.Set("DD-APPLICATION-KEY", c.appKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// NewClient returns a new datadog client. If host is empty, DefaultHost
+// is used. The appKey is only required to query events.
+func NewClient(host, apiKey, appKey string) *Client {
+	if host == "" {
+		host = DefaultHost
+	}
+	return &Client{
+		host:   host,
+		apiKey: apiKey,
+		appKey: appKey,
+		client: http.DefaultClient,
+	}
+}
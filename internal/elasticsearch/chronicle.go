@@ -0,0 +1,316 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/problems"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// Document represents a release event indexed in Elasticsearch.
+type Document struct {
+	Timestamp time.Time  `json:"@timestamp"`
+	Cluster   string     `json:"cluster,omitempty"`
+	Type      string     `json:"release_type"`
+	Status    string     `json:"release_status"`
+	Name      string     `json:"release_name"`
+	Revision  string     `json:"release_revision"`
+	Namespace string     `json:"release_namespace"`
+	Deleted   bool       `json:"deleted"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// DocumentFromEvent assembles a document from the chronologist release event.
+func DocumentFromEvent(cluster string, re chronologist.ReleaseEvent) Document {
+	return Document{
+		Timestamp: re.Time,
+		Cluster:   cluster,
+		Type:      re.Type.String(),
+		Status:    re.Status,
+		Name:      re.Name,
+		Revision:  re.Revision,
+		Namespace: re.Namespace,
+	}
+}
+
+// ID returns the document id. The id is stable for the same release
+// revision, so indexing the document again replaces it.
+func (d Document) ID() string {
+	return strings.Join([]string{d.Cluster, d.Namespace, d.Name, d.Revision}, "/")
+}
+
+// Options represent Elasticsearch chronicle options.
+type Options struct {
+	// Cluster is the name of the cluster, stored in every document.
+	Cluster string
+
+	// IndexPrefix is the prefix of index names.
+	IndexPrefix string
+
+	// IndexDateLayout is the Go time layout appended to IndexPrefix to name
+	// an index for a release event, e.g. "2006.01" for an index per month.
+	IndexDateLayout string
+
+	// SoftDelete marks documents as deleted instead of removing them.
+	SoftDelete bool
+
+	// BatchSize is the number of documents that triggers a bulk request.
+	BatchSize int
+
+	// BatchWait is the maximum amount of time a document waits in the
+	// batch before it is indexed.
+	BatchWait time.Duration
+}
+
+// NewChronicle returns a new Elasticsearch chronicle.
+func NewChronicle(es Indexer, log *zap.Logger, opts Options) *Chronicle {
+	if opts.IndexPrefix == "" {
+		opts.IndexPrefix = "chronologist"
+	}
+	if opts.IndexDateLayout == "" {
+		opts.IndexDateLayout = "2006.01"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BatchWait <= 0 {
+		opts.BatchWait = time.Second * 5
+	}
+
+	return &Chronicle{
+		es:       es,
+		log:      log,
+		opts:     opts,
+		flushReq: make(chan struct{}, 1),
+	}
+}
+
+// A Chronicle registers release events in Elasticsearch as documents.
+//
+// Documents are indexed in bulk either when the batch is full or when
+// BatchWait elapses, whatever happens first. Run must be started to index
+// the batches.
+type Chronicle struct {
+	es   Indexer
+	log  *zap.Logger
+	opts Options
+
+	mx      sync.Mutex
+	pending map[string]BulkItem
+
+	flushReq chan struct{}
+}
+
+// Setup installs the index template which maps release fields as keywords,
+// so documents can be matched exactly on Unregister.
+func (c *Chronicle) Setup(ctx context.Context) error {
+	keyword := map[string]string{"type": "keyword"}
+	template := map[string]interface{}{
+		"index_patterns": []string{c.opts.IndexPrefix + "-*"},
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"@timestamp":        map[string]string{"type": "date"},
+				"deleted_at":        map[string]string{"type": "date"},
+				"deleted":           map[string]string{"type": "boolean"},
+				"cluster":           keyword,
+				"release_type":      keyword,
+				"release_status":    keyword,
+				"release_name":      keyword,
+				"release_revision":  keyword,
+				"release_namespace": keyword,
+			},
+		},
+	}
+
+	err := c.es.PutIndexTemplate(ctx, c.opts.IndexPrefix, template)
+	return errors.Wrap(err, "put index template")
+}
+
+// Register adds the release event to the chronicle, queueing the document
+// to be indexed.
+func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	doc := DocumentFromEvent(c.opts.Cluster, re)
+	item := BulkItem{
+		Index:    c.index(re.Time),
+		ID:       doc.ID(),
+		Document: doc,
+	}
+
+	c.mx.Lock()
+	if c.pending == nil {
+		c.pending = make(map[string]BulkItem)
+	}
+	// Later events for the same document supersede earlier ones.
+	c.pending[item.ID] = item
+	full := len(c.pending) >= c.opts.BatchSize
+	c.mx.Unlock()
+
+	if full {
+		select {
+		case c.flushReq <- struct{}{}:
+		default:
+		}
+	}
+
+	zaplog.Grasp(ctx, c.log).Debug("Queued release event to be indexed in Elasticsearch")
+	return nil
+}
+
+// Unregister removes the release event from the chronicle, deleting or
+// marking as deleted the corresponding documents.
+func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
+	log := zaplog.Grasp(ctx, c.log)
+
+	// Index pending documents first, otherwise they would be indexed
+	// after the deletion.
+	if err := c.Flush(ctx); err != nil {
+		return err
+	}
+
+	filter := []interface{}{
+		term("release_name", name),
+		term("release_revision", revision),
+	}
+	if c.opts.Cluster != "" {
+		filter = append(filter, term("cluster", c.opts.Cluster))
+	}
+	query := map[string]interface{}{
+		"bool": map[string]interface{}{"filter": filter},
+	}
+	index := c.opts.IndexPrefix + "-*"
+
+	if c.opts.SoftDelete {
+		log.Debug("Marking Elasticsearch documents related to the release event as deleted")
+		err := c.es.UpdateByQuery(ctx, index, query, Script{
+			Source: "ctx._source.deleted = true; ctx._source.deleted_at = params.deleted_at",
+			Lang:   "painless",
+			Params: map[string]interface{}{"deleted_at": time.Now().UTC()},
+		})
+		return errors.Wrap(err, "mark documents as deleted")
+	}
+
+	log.Debug("Deleting Elasticsearch documents related to the release event")
+	err := c.es.DeleteByQuery(ctx, index, query)
+	return errors.Wrap(err, "delete documents")
+}
+
+// Run indexes batches of documents until stopCh is closed. Pending documents
+// are indexed one last time before Run returns.
+func (c *Chronicle) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(c.opts.BatchWait)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			if err := c.Flush(context.Background()); err != nil {
+				c.log.Sugar().Errorf("Failed to index last batch in Elasticsearch: %s", err)
+			}
+			return
+		case <-ticker.C:
+		case <-c.flushReq:
+		}
+
+		if err := c.Flush(context.Background()); err != nil {
+			c.log.Sugar().Errorf("Failed to index batch in Elasticsearch: %s", err)
+		}
+	}
+}
+
+// Flush indexes all pending documents. Documents that fail to be indexed
+// because Elasticsearch is unavailable or overloaded are kept to be indexed
+// on the next attempt. Documents Elasticsearch rejects, e.g. because they
+// do not match the mapping, are logged and dropped, as indexing them again
+// would fail forever.
+func (c *Chronicle) Flush(ctx context.Context) error {
+	c.mx.Lock()
+	batch := c.pending
+	c.pending = nil
+	c.mx.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	items := make([]BulkItem, 0, len(batch))
+	for _, item := range batch {
+		items = append(items, item)
+	}
+
+	results, err := c.es.Bulk(ctx, items)
+	if err != nil {
+		if !chronologist.IsRetryable(err) {
+			return errors.Wrapf(err, "index documents in bulk, dropped %d documents", len(items))
+		}
+		c.requeue(items)
+		return errors.Wrap(err, "index documents in bulk")
+	}
+
+	var retry []BulkItem
+	var errs []error
+	for _, res := range results {
+		if !res.Failed() {
+			continue
+		}
+		if !res.Temporary() {
+			c.log.Sugar().Errorf("Dropping document %s rejected by Elasticsearch: %s", res.ID, res.Error)
+			continue
+		}
+		if item, ok := batch[res.ID]; ok {
+			retry = append(retry, item)
+		}
+		errs = append(errs, errors.Errorf("bulk item %s failed: %s", res.ID, res.Error))
+	}
+	c.requeue(retry)
+
+	return problems.NewAggregate(errs)
+}
+
+// requeue queues the documents to be indexed again.
+func (c *Chronicle) requeue(items []BulkItem) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.pending == nil {
+		c.pending = make(map[string]BulkItem)
+	}
+	for _, item := range items {
+		// Do not overwrite documents queued during the failed attempt.
+		if _, ok := c.pending[item.ID]; !ok {
+			c.pending[item.ID] = item
+		}
+	}
+}
+
+func (c *Chronicle) index(t time.Time) string {
+	return c.opts.IndexPrefix + "-" + t.UTC().Format(c.opts.IndexDateLayout)
+}
+
+func term(field, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"term": map[string]string{field: value},
+	}
+}
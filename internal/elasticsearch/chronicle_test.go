@@ -0,0 +1,288 @@
+package elasticsearch_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/elasticsearch"
+)
+
+// fakeElasticsearch records requests to Elasticsearch and responds with
+// the configured bulk item errors.
+type fakeElasticsearch struct {
+	mx       sync.Mutex
+	requests []request
+
+	// status is the response status, if not 200.
+	status int
+	// failIDs are ids of bulk items that fail with the status.
+	failIDs map[string]int
+}
+
+type request struct {
+	method string
+	path   string
+	// body holds JSON objects of the request body, one per line for bulk
+	// requests.
+	body []map[string]interface{}
+}
+
+func (f *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "elastic" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	req := request{method: r.Method, path: r.URL.Path}
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &obj); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req.body = append(req.body, obj)
+	}
+	f.requests = append(f.requests, req)
+
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		return
+	}
+
+	if r.URL.Path != "/_bulk" {
+		json.NewEncoder(w).Encode(map[string]interface{}{})
+		return
+	}
+
+	var items []interface{}
+	var failed bool
+	for i := 0; i < len(req.body); i += 2 {
+		id := req.body[i]["index"].(map[string]interface{})["_id"].(string)
+		res := map[string]interface{}{"_id": id, "status": 201}
+		switch f.failIDs[id] {
+		case 0:
+		case http.StatusTooManyRequests:
+			failed = true
+			res["status"] = http.StatusTooManyRequests
+			res["error"] = map[string]string{"type": "es_rejected_execution_exception", "reason": "queue is full"}
+		default:
+			failed = true
+			res["status"] = f.failIDs[id]
+			res["error"] = map[string]string{"type": "mapper_parsing_exception", "reason": "failed to parse"}
+		}
+		items = append(items, map[string]interface{}{"index": res})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": failed, "items": items})
+}
+
+func (f *fakeElasticsearch) Requests() []request {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]request(nil), f.requests...)
+}
+
+func releaseEvent(revision, status string) chronologist.ReleaseEvent {
+	return chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    status,
+		Name:      "foo",
+		Revision:  revision,
+		Namespace: "default",
+	}
+}
+
+// Tests that chronicle indexes pending documents in a single bulk request,
+// keeping only the latest document of each release revision.
+func TestChronicle_Flush(t *testing.T) {
+	es := &fakeElasticsearch{}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+
+	cr := elasticsearch.NewChronicle(elasticsearch.NewClient(srv.URL, "elastic", "secret"), zap.NewNop(), elasticsearch.Options{
+		Cluster: "dev",
+	})
+	ctx := context.Background()
+
+	require.NoError(t, cr.Register(ctx, releaseEvent("1", "DEPLOYED")))
+	require.NoError(t, cr.Register(ctx, releaseEvent("1", "SUPERSEDED")))
+	require.NoError(t, cr.Flush(ctx))
+
+	// Nothing is pending.
+	require.NoError(t, cr.Flush(ctx))
+
+	requests := es.Requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, http.MethodPost, requests[0].method)
+	assert.Equal(t, "/_bulk", requests[0].path)
+	require.Len(t, requests[0].body, 2)
+
+	action, err := json.Marshal(requests[0].body[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"index":{"_index":"chronologist-2019.01","_id":"dev/default/foo/1"}}`, string(action))
+
+	doc, err := json.Marshal(requests[0].body[1])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"@timestamp":"2019-01-02T15:04:05Z","cluster":"dev","release_type":"rollout",`+
+		`"release_status":"SUPERSEDED","release_name":"foo","release_revision":"1",`+
+		`"release_namespace":"default","deleted":false}`, string(doc))
+}
+
+// Tests that chronicle keeps documents when the bulk request fails or any
+// of its items is rejected temporarily, and indexes them on the next flush.
+// Documents rejected permanently are dropped.
+func TestChronicle_Flush_retry(t *testing.T) {
+	es := &fakeElasticsearch{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+
+	cr := elasticsearch.NewChronicle(elasticsearch.NewClient(srv.URL, "elastic", "secret"), zap.NewNop(), elasticsearch.Options{})
+	ctx := context.Background()
+
+	require.NoError(t, cr.Register(ctx, releaseEvent("1", "DEPLOYED")))
+	assert.Error(t, cr.Flush(ctx))
+
+	// A document queued during the failed attempt is not overwritten by the
+	// failed one.
+	require.NoError(t, cr.Register(ctx, releaseEvent("2", "DEPLOYED")))
+	require.NoError(t, cr.Register(ctx, releaseEvent("3", "DEPLOYED")))
+
+	es.mx.Lock()
+	es.status = 0
+	es.failIDs = map[string]int{
+		"/default/foo/2": http.StatusTooManyRequests,
+		"/default/foo/3": http.StatusBadRequest,
+	}
+	es.mx.Unlock()
+
+	err := cr.Flush(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "bulk item /default/foo/2 failed: es_rejected_execution_exception: queue is full")
+		assert.NotContains(t, err.Error(), "/default/foo/3")
+	}
+
+	es.mx.Lock()
+	es.failIDs = nil
+	es.mx.Unlock()
+
+	require.NoError(t, cr.Flush(ctx))
+	require.NoError(t, cr.Flush(ctx))
+
+	requests := es.Requests()
+	require.Len(t, requests, 3)
+	assert.Len(t, requests[0].body, 2)
+	assert.Len(t, requests[1].body, 6)
+	// Only the document rejected temporarily is indexed again.
+	require.Len(t, requests[2].body, 2)
+	assert.Equal(t, "/default/foo/2", requests[2].body[0]["index"].(map[string]interface{})["_id"])
+}
+
+// Tests that chronicle drops documents when Elasticsearch rejects the whole
+// bulk request, as sending it again would fail forever.
+func TestChronicle_Flush_rejected(t *testing.T) {
+	es := &fakeElasticsearch{status: http.StatusBadRequest}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+
+	cr := elasticsearch.NewChronicle(elasticsearch.NewClient(srv.URL, "elastic", "secret"), zap.NewNop(), elasticsearch.Options{})
+	ctx := context.Background()
+
+	require.NoError(t, cr.Register(ctx, releaseEvent("1", "DEPLOYED")))
+	assert.Error(t, cr.Flush(ctx))
+
+	// Nothing is pending.
+	require.NoError(t, cr.Flush(ctx))
+	assert.Len(t, es.Requests(), 1)
+}
+
+// Tests that chronicle indexes pending documents before unregistering, and
+// deletes documents of the release revision, or marks them as deleted.
+func TestChronicle_Unregister(t *testing.T) {
+	testCases := []struct {
+		name       string
+		softDelete bool
+		path       string
+	}{
+		{
+			name: "hard delete",
+			path: "/chronologist-*/_delete_by_query",
+		},
+		{
+			name:       "soft delete",
+			softDelete: true,
+			path:       "/chronologist-*/_update_by_query",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			es := &fakeElasticsearch{}
+			srv := httptest.NewServer(es)
+			defer srv.Close()
+
+			cr := elasticsearch.NewChronicle(elasticsearch.NewClient(srv.URL, "elastic", "secret"), zap.NewNop(), elasticsearch.Options{
+				Cluster:    "dev",
+				SoftDelete: tc.softDelete,
+			})
+			ctx := context.Background()
+
+			require.NoError(t, cr.Register(ctx, releaseEvent("1", "DEPLOYED")))
+			require.NoError(t, cr.Unregister(ctx, "foo", "1"))
+
+			requests := es.Requests()
+			require.Len(t, requests, 2)
+			assert.Equal(t, "/_bulk", requests[0].path)
+			assert.Equal(t, tc.path, requests[1].path)
+			require.Len(t, requests[1].body, 1)
+			body := requests[1].body[0]
+
+			query, err := json.Marshal(body["query"])
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"bool":{"filter":[{"term":{"release_name":"foo"}},`+
+				`{"term":{"release_revision":"1"}},{"term":{"cluster":"dev"}}]}}`, string(query))
+
+			if !tc.softDelete {
+				assert.NotContains(t, body, "script")
+				return
+			}
+			script, ok := body["script"].(map[string]interface{})
+			require.True(t, ok)
+			assert.Equal(t, "ctx._source.deleted = true; ctx._source.deleted_at = params.deleted_at", script["source"])
+			assert.Equal(t, "painless", script["lang"])
+			assert.Contains(t, script["params"], "deleted_at")
+		})
+	}
+}
+
+// Tests that unregistering fails without touching documents when pending
+// documents cannot be indexed.
+func TestChronicle_Unregister_flushError(t *testing.T) {
+	es := &fakeElasticsearch{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+
+	cr := elasticsearch.NewChronicle(elasticsearch.NewClient(srv.URL, "elastic", "secret"), zap.NewNop(), elasticsearch.Options{})
+	ctx := context.Background()
+
+	require.NoError(t, cr.Register(ctx, releaseEvent("1", "DEPLOYED")))
+	assert.Error(t, cr.Unregister(ctx, "foo", "1"))
+
+	requests := es.Requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "/_bulk", requests[0].path)
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// BulkItem represents a single index operation of the bulk request.
+type BulkItem struct {
+	Index    string
+	ID       string
+	Document interface{}
+}
+
+// BulkResult is the result of a single index operation of the bulk request.
+type BulkResult struct {
+	ID string
+
+	// Status is the HTTP status code of the operation.
+	Status int
+
+	// Error describes why the operation failed, if it did.
+	Error string
+}
+
+// Failed reports whether the operation failed.
+func (r BulkResult) Failed() bool {
+	return r.Error != "" || r.Status >= 300
+}
+
+// Temporary reports whether the operation may succeed if retried later,
+// e.g. when Elasticsearch rejected it because its queues were full.
+func (r BulkResult) Temporary() bool {
+	return r.Status == http.StatusTooManyRequests || r.Status >= 500
+}
+
+// APIError is returned when Elasticsearch responds with an unexpected status.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Message is the response body, truncated.
+	Message string
+}
+
+// Error implements error.
+func (e *APIError) Error() string {
+	s := fmt.Sprintf("elasticsearch api: got response %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// Temporary reports whether the request may succeed if retried later.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// maxErrorBodySize limits how much of the response body is read into the
+// error message.
+const maxErrorBodySize = 512
+
+// Indexer can index and remove documents.
+type Indexer interface {
+	// Bulk indexes documents, creating or replacing them by their ids.
+	// It fails only if the whole request fails; results tell which of the
+	// documents were not indexed.
+	Bulk(ctx context.Context, items []BulkItem) ([]BulkResult, error)
+
+	// DeleteByQuery deletes documents matching the query.
+	DeleteByQuery(ctx context.Context, index string, query interface{}) error
+
+	// UpdateByQuery updates documents matching the query using the script.
+	UpdateByQuery(ctx context.Context, index string, query interface{}, script Script) error
+
+	// PutIndexTemplate creates or updates the index template.
+	PutIndexTemplate(ctx context.Context, name string, template interface{}) error
+}
+
+// Script represents a painless script used in update requests.
+type Script struct {
+	Source string                 `json:"source"`
+	Lang   string                 `json:"lang"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// Client is an Elasticsearch HTTP API client. It is compatible with
+// OpenSearch as well.
+//
+// Client implements Indexer interface.
+type Client struct {
+	host     string
+	username string
+	password string
+
+	client *http.Client
+}
+
+// Bulk indexes documents using the bulk API.
+//
+// See: https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
+func (c *Client) Bulk(ctx context.Context, items []BulkItem) ([]BulkResult, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, item := range items {
+		action := map[string]interface{}{
+			"index": map[string]string{
+				"_index": item.Index,
+				"_id":    item.ID,
+			},
+		}
+		if err := enc.Encode(action); err != nil {
+			return nil, errors.Wrap(err, "encode bulk action to json")
+		}
+		if err := enc.Encode(item.Document); err != nil {
+			return nil, errors.Wrap(err, "encode document to json")
+		}
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", &buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var br bulkResponse
+	if err = json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return nil, errors.Wrap(err, "decode response body from json")
+	}
+
+	results := make([]BulkResult, 0, len(br.Items))
+	for _, item := range br.Items {
+		for _, res := range item {
+			result := BulkResult{ID: res.ID, Status: res.Status}
+			if res.Error != nil {
+				result.Error = res.Error.Type + ": " + res.Error.Reason
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// DeleteByQuery deletes documents matching the query.
+//
+// See: https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-delete-by-query.html
+func (c *Client) DeleteByQuery(ctx context.Context, index string, query interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{"query": query})
+	if err != nil {
+		return errors.Wrap(err, "encode request to json")
+	}
+
+	path := fmt.Sprintf("/%s/_delete_by_query?conflicts=proceed&refresh=true", url.PathEscape(index))
+	resp, err := c.do(ctx, http.MethodPost, path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// UpdateByQuery updates documents matching the query using the script.
+//
+// See: https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-update-by-query.html
+func (c *Client) UpdateByQuery(ctx context.Context, index string, query interface{}, script Script) error {
+	b, err := json.Marshal(map[string]interface{}{
+		"query":  query,
+		"script": script,
+	})
+	if err != nil {
+		return errors.Wrap(err, "encode request to json")
+	}
+
+	path := fmt.Sprintf("/%s/_update_by_query?conflicts=proceed&refresh=true", url.PathEscape(index))
+	resp, err := c.do(ctx, http.MethodPost, path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// PutIndexTemplate creates or updates the legacy index template, which is
+// supported by both Elasticsearch and OpenSearch.
+//
+// See: https://www.elastic.co/guide/en/elasticsearch/reference/7.10/indices-templates-v1.html
+func (c *Client) PutIndexTemplate(ctx context.Context, name string, template interface{}) error {
+	b, err := json.Marshal(template)
+	if err != nil {
+		return errors.Wrap(err, "encode request to json")
+	}
+
+	path := "/_template/" + url.PathEscape(name)
+	resp, err := c.do(ctx, http.MethodPut, path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.host+path, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "do request")
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		defer resp.Body.Close()
+		e := &APIError{StatusCode: resp.StatusCode}
+		if b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize)); err == nil {
+			e.Message = string(bytes.TrimSpace(b))
+		}
+		return nil, e
+	}
+
+	return resp, nil
+}
+
+// NewClient returns a new Elasticsearch client. Username and password are
+// optional and enable basic authentication when set.
+func NewClient(host, username, password string) *Client {
+	return &Client{
+		host:     host,
+		username: username,
+		password: password,
+		client:   http.DefaultClient,
+	}
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkItemResponse `json:"items"`
+}
+
+type bulkItemResponse struct {
+	ID     string `json:"_id"`
+	Status int    `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
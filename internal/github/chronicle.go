@@ -0,0 +1,234 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+const (
+	// AnnotationRepository is the chart annotation that maps the release
+	// to a GitHub repository, e.g. "acme/api".
+	AnnotationRepository = "chronologist.io/github-repository"
+
+	// AnnotationRef is the chart annotation that holds the commit SHA,
+	// branch or tag the chart was built from. When absent, chart appVersion
+	// is used as the ref.
+	AnnotationRef = "chronologist.io/git-ref"
+)
+
+// Deployment states.
+const (
+	StateInProgress = "in_progress"
+	StateSuccess    = "success"
+	StateFailure    = "failure"
+	StateInactive   = "inactive"
+	StateError      = "error"
+)
+
+// StateFromStatus returns the deployment state for the helm release status.
+func StateFromStatus(status string) string {
+	switch {
+	case status == "DEPLOYED":
+		return StateSuccess
+	case status == "FAILED":
+		return StateFailure
+	case strings.HasPrefix(status, "PENDING"):
+		return StateInProgress
+	case status == "SUPERSEDED", status == "DELETED", status == "DELETING":
+		return StateInactive
+	default:
+		return StateError
+	}
+}
+
+// Options represent GitHub chronicle options.
+type Options struct {
+	// Cluster is the name of the cluster. When set, environments are named
+	// "<cluster>/<namespace>" instead of just "<namespace>".
+	Cluster string
+
+	// Repositories maps release names to repositories. Chart annotation
+	// takes precedence over this mapping.
+	Repositories map[string]string
+}
+
+// NewChronicle returns a new GitHub chronicle.
+func NewChronicle(github Deployer, log *zap.Logger, opts Options) *Chronicle {
+	return &Chronicle{
+		github:      github,
+		log:         log,
+		opts:        opts,
+		deployments: make(map[string]deploymentRef),
+	}
+}
+
+// A Chronicle reflects release events as GitHub deployments.
+//
+// Each release revision is a deployment of the chart ref to the environment
+// named after the release namespace. Deployment statuses follow the release
+// status, and the deployment becomes inactive when the release revision is
+// removed.
+type Chronicle struct {
+	github Deployer
+	log    *zap.Logger
+	opts   Options
+
+	mx sync.Mutex
+	// deployments remembers repositories, environments and refs of release
+	// revisions, because chart metadata is not available on Unregister.
+	deployments map[string]deploymentRef
+}
+
+type deploymentRef struct {
+	repo        string
+	environment string
+	ref         string
+}
+
+// Register adds the release event to the chronicle, creating a deployment
+// for the release revision if needed and syncing its status.
+func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	log := zaplog.Grasp(ctx, c.log)
+
+	repo := re.Chart.Annotations[AnnotationRepository]
+	if repo == "" {
+		repo = c.opts.Repositories[re.Name]
+	}
+	if repo == "" {
+		log.Debug("Release is not mapped to a GitHub repository, skip")
+		return nil
+	}
+
+	ref := re.Chart.Annotations[AnnotationRef]
+	if ref == "" {
+		ref = re.Chart.AppVersion
+	}
+	if ref == "" {
+		log.Sugar().Warnf("Release is mapped to GitHub repository %s but has no ref to deploy, skip", repo)
+		return nil
+	}
+
+	environment := c.environment(re.Namespace)
+
+	d, found, err := c.findDeployment(ctx, repo, DeploymentFilter{Environment: environment, Ref: ref}, re.Name, re.Revision)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		log.Sugar().Debugf("Creating GitHub deployment of %s@%s to %s", repo, ref, environment)
+		d, err = c.github.CreateDeployment(ctx, repo, Deployment{
+			Ref:         ref,
+			Environment: environment,
+			Description: re.Summary(),
+			Payload: DeploymentPayload{
+				Heritage:  "chronologist",
+				Release:   re.Name,
+				Revision:  re.Revision,
+				Namespace: re.Namespace,
+				Cluster:   c.opts.Cluster,
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "create deployment")
+		}
+	}
+
+	c.mx.Lock()
+	c.deployments[re.Name+"/"+re.Revision] = deploymentRef{repo: repo, environment: environment, ref: ref}
+	c.mx.Unlock()
+
+	return c.syncStatus(ctx, repo, d.ID, StateFromStatus(re.Status))
+}
+
+// Unregister removes the release event from the chronicle, marking the
+// corresponding deployment as inactive.
+func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
+	log := zaplog.Grasp(ctx, c.log)
+
+	key := name + "/" + revision
+
+	c.mx.Lock()
+	ref, ok := c.deployments[key]
+	delete(c.deployments, key)
+	c.mx.Unlock()
+
+	if !ok {
+		// Chart metadata is not available here, so fall back to the
+		// configured mapping, looking through all environments.
+		ref = deploymentRef{repo: c.opts.Repositories[name]}
+	}
+	if ref.repo == "" {
+		log.Debug("Release is not mapped to a GitHub repository, skip")
+		return nil
+	}
+
+	d, found, err := c.findDeployment(ctx, ref.repo, DeploymentFilter{Environment: ref.environment, Ref: ref.ref}, name, revision)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	return c.syncStatus(ctx, ref.repo, d.ID, StateInactive)
+}
+
+func (c *Chronicle) findDeployment(ctx context.Context, repo string, filter DeploymentFilter, name, revision string) (Deployment, bool, error) {
+	dd, err := c.github.ListDeployments(ctx, repo, filter)
+	if err != nil {
+		return Deployment{}, false, errors.Wrap(err, "list deployments")
+	}
+
+	for _, d := range dd {
+		p := d.Payload
+		if p.Heritage == "chronologist" && p.Release == name && p.Revision == revision && p.Cluster == c.opts.Cluster {
+			return d, true, nil
+		}
+	}
+	return Deployment{}, false, nil
+}
+
+func (c *Chronicle) syncStatus(ctx context.Context, repo string, deploymentID int64, state string) error {
+	ss, err := c.github.ListDeploymentStatuses(ctx, repo, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "list deployment statuses")
+	}
+
+	if len(ss) > 0 && ss[0].State == state {
+		return nil
+	}
+
+	err = c.github.CreateDeploymentStatus(ctx, repo, deploymentID, DeploymentStatus{State: state})
+	return errors.Wrap(err, "create deployment status")
+}
+
+func (c *Chronicle) environment(namespace string) string {
+	if c.opts.Cluster != "" {
+		return c.opts.Cluster + "/" + namespace
+	}
+	return namespace
+}
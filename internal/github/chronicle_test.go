@@ -0,0 +1,219 @@
+package github_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/github"
+)
+
+// fakeGitHub implements deployment endpoints of GitHub API for a single
+// repository. It serves deployments in pages of pageSize, newest first.
+type fakeGitHub struct {
+	pageSize int
+
+	mx          sync.Mutex
+	deployments []github.Deployment
+	statuses    map[int64][]github.DeploymentStatus
+	queries     []string
+}
+
+func newFakeGitHub(pageSize int) *fakeGitHub {
+	return &fakeGitHub{pageSize: pageSize, statuses: make(map[int64][]github.DeploymentStatus)}
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if r.Header.Get("Authorization") != "token secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/repos/acme/api/deployments"
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	switch {
+	case path == "" && r.Method == http.MethodPost:
+		var d github.Deployment
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		d.ID = int64(len(f.deployments) + 1)
+		f.deployments = append([]github.Deployment{d}, f.deployments...)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(d)
+	case path == "" && r.Method == http.MethodGet:
+		f.queries = append(f.queries, r.URL.RawQuery)
+		var dd []github.Deployment
+		for _, d := range f.deployments {
+			if env := r.URL.Query().Get("environment"); env != "" && env != d.Environment {
+				continue
+			}
+			if ref := r.URL.Query().Get("ref"); ref != "" && ref != d.Ref {
+				continue
+			}
+			dd = append(dd, d)
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		from, to := (page-1)*f.pageSize, page*f.pageSize
+		if to < len(dd) {
+			q := r.URL.Query()
+			q.Set("page", strconv.Itoa(page+1))
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?%s>; rel="next", <http://%s%s>; rel="first"`,
+				r.Host, prefix, q.Encode(), r.Host, prefix))
+		} else {
+			to = len(dd)
+		}
+		if from > to {
+			from = to
+		}
+		json.NewEncoder(w).Encode(dd[from:to])
+	case strings.HasSuffix(path, "/statuses"):
+		id, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/statuses"), 10, 64)
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(f.statuses[id])
+			return
+		}
+		var s github.DeploymentStatus
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.statuses[id] = append([]github.DeploymentStatus{s}, f.statuses[id]...)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeGitHub) states(id int64) []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	var states []string
+	for _, s := range f.statuses[id] {
+		states = append(states, s.State)
+	}
+	return states
+}
+
+func releaseEvent(revision, status string) chronologist.ReleaseEvent {
+	return chronologist.ReleaseEvent{
+		Status:    status,
+		Name:      "api",
+		Revision:  revision,
+		Namespace: "default",
+		Chart: chronologist.Chart{
+			AppVersion:  "v1." + revision,
+			Annotations: map[string]string{github.AnnotationRepository: "acme/api"},
+		},
+	}
+}
+
+// Tests that chronicle creates a deployment with a status for a new release
+// revision, and updates the status only when it changes.
+func TestChronicle_Register(t *testing.T) {
+	gh := newFakeGitHub(100)
+	srv := httptest.NewServer(gh)
+	defer srv.Close()
+
+	cr := github.NewChronicle(github.NewClient(srv.URL, "secret"), zap.NewNop(), github.Options{Cluster: "dev"})
+	ctx := context.Background()
+
+	require.NoError(t, cr.Register(ctx, releaseEvent("1", "DEPLOYED")))
+	require.NoError(t, cr.Register(ctx, releaseEvent("1", "DEPLOYED")))
+
+	require.Len(t, gh.deployments, 1)
+	d := gh.deployments[0]
+	assert.Equal(t, "v1.1", d.Ref)
+	assert.Equal(t, "dev/default", d.Environment)
+	assert.Equal(t, github.DeploymentPayload{
+		Heritage:  "chronologist",
+		Release:   "api",
+		Revision:  "1",
+		Namespace: "default",
+		Cluster:   "dev",
+	}, d.Payload)
+	assert.Equal(t, []string{github.StateSuccess}, gh.states(d.ID))
+
+	require.NoError(t, cr.Register(ctx, releaseEvent("1", "SUPERSEDED")))
+	require.Len(t, gh.deployments, 1)
+	assert.Equal(t, []string{github.StateInactive, github.StateSuccess}, gh.states(d.ID))
+
+	for _, q := range gh.queries {
+		assert.Contains(t, q, "ref=v1.1")
+	}
+}
+
+// Tests that chronicle finds an existing deployment beyond the first page
+// of results instead of creating a duplicate.
+func TestChronicle_Register_pagination(t *testing.T) {
+	gh := newFakeGitHub(2)
+	srv := httptest.NewServer(gh)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	// Deployments of the same ref to other environments and clusters fill
+	// the first pages.
+	client := github.NewClient(srv.URL, "secret")
+	first := github.NewChronicle(client, zap.NewNop(), github.Options{})
+	require.NoError(t, first.Register(ctx, releaseEvent("1", "DEPLOYED")))
+	for _, cluster := range []string{"a", "b", "c", "d"} {
+		cr := github.NewChronicle(client, zap.NewNop(), github.Options{Cluster: cluster})
+		require.NoError(t, cr.Register(ctx, releaseEvent("1", "DEPLOYED")))
+	}
+	require.Len(t, gh.deployments, 5)
+
+	dd, err := client.ListDeployments(ctx, "acme/api", github.DeploymentFilter{Ref: "v1.1"})
+	require.NoError(t, err)
+	assert.Len(t, dd, 5)
+
+	// A fresh chronicle has no memory of the deployment and has to list
+	// all pages to find it.
+	cr := github.NewChronicle(client, zap.NewNop(), github.Options{Repositories: map[string]string{"api": "acme/api"}})
+	require.NoError(t, cr.Register(ctx, releaseEvent("1", "DEPLOYED")))
+	assert.Len(t, gh.deployments, 5)
+
+	require.NoError(t, cr.Unregister(ctx, "api", "1"))
+	assert.Equal(t, []string{github.StateInactive, github.StateSuccess}, gh.states(1))
+}
+
+// Tests that chronicle marks the deployment inactive on Unregister even if
+// it has not seen the release before, using the configured mapping.
+func TestChronicle_Unregister(t *testing.T) {
+	gh := newFakeGitHub(100)
+	srv := httptest.NewServer(gh)
+	defer srv.Close()
+
+	client := github.NewClient(srv.URL, "secret")
+	ctx := context.Background()
+
+	require.NoError(t, github.NewChronicle(client, zap.NewNop(), github.Options{}).Register(ctx, releaseEvent("1", "DEPLOYED")))
+
+	cr := github.NewChronicle(client, zap.NewNop(), github.Options{Repositories: map[string]string{"api": "acme/api"}})
+	require.NoError(t, cr.Unregister(ctx, "api", "1"))
+	assert.Equal(t, []string{github.StateInactive, github.StateSuccess}, gh.states(1))
+
+	// Unknown revisions are ignored.
+	require.NoError(t, cr.Unregister(ctx, "api", "2"))
+	assert.Len(t, gh.deployments, 1)
+}
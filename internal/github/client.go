@@ -0,0 +1,253 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultHost is the address of GitHub API. For GitHub Enterprise, use
+// "https://github.example.com/api/v3".
+const DefaultHost = "https://api.github.com"
+
+// Deployment represents GitHub deployment.
+type Deployment struct {
+	ID          int64             `json:"id,omitempty"`
+	Ref         string            `json:"ref"`
+	Environment string            `json:"environment"`
+	Description string            `json:"description,omitempty"`
+	Payload     DeploymentPayload `json:"payload"`
+}
+
+// DeploymentPayload represents extra information Chronologist attaches to
+// deployments to find them later.
+type DeploymentPayload struct {
+	Heritage  string `json:"heritage,omitempty"`
+	Release   string `json:"release,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Deployments created by other
+// tools may have payloads of any shape, which are ignored.
+func (p *DeploymentPayload) UnmarshalJSON(b []byte) error {
+	type payload DeploymentPayload
+	var v payload
+	if err := json.Unmarshal(b, &v); err != nil {
+		*p = DeploymentPayload{}
+		return nil
+	}
+	*p = DeploymentPayload(v)
+	return nil
+}
+
+// DeploymentStatus represents GitHub deployment status.
+type DeploymentStatus struct {
+	ID          int64  `json:"id,omitempty"`
+	State       string `json:"state"`
+	Description string `json:"description,omitempty"`
+	LogURL      string `json:"log_url,omitempty"`
+}
+
+// DeploymentFilter narrows the list of deployments. Zero values mean no
+// filtering.
+type DeploymentFilter struct {
+	Environment string
+
+	// Ref is the branch, tag or SHA deployments were created for.
+	Ref string
+}
+
+// Deployer can manage deployments.
+type Deployer interface {
+	// CreateDeployment creates a deployment in the repository.
+	CreateDeployment(ctx context.Context, repo string, d Deployment) (Deployment, error)
+
+	// ListDeployments returns all deployments of the repository that match
+	// the filter.
+	ListDeployments(ctx context.Context, repo string, filter DeploymentFilter) ([]Deployment, error)
+
+	// CreateDeploymentStatus creates a status of the deployment.
+	CreateDeploymentStatus(ctx context.Context, repo string, deploymentID int64, s DeploymentStatus) error
+
+	// ListDeploymentStatuses returns statuses of the deployment, the most
+	// recent first.
+	ListDeploymentStatuses(ctx context.Context, repo string, deploymentID int64) ([]DeploymentStatus, error)
+}
+
+// Client is a GitHub HTTP API client. Repositories are referred to as
+// "owner/name".
+//
+// Client implements Deployer interface.
+type Client struct {
+	host  string
+	token string
+
+	client *http.Client
+}
+
+// CreateDeployment creates a deployment.
+//
+// See: https://docs.github.com/en/rest/deployments/deployments#create-a-deployment
+func (c *Client) CreateDeployment(ctx context.Context, repo string, d Deployment) (Deployment, error) {
+	// Release is already deployed to the cluster, so do not let GitHub
+	// merge the default branch or check commit statuses.
+	body := struct {
+		Deployment
+		AutoMerge        bool     `json:"auto_merge"`
+		RequiredContexts []string `json:"required_contexts"`
+	}{
+		Deployment:       d,
+		AutoMerge:        false,
+		RequiredContexts: []string{},
+	}
+
+	var created Deployment
+	_, err := c.do(ctx, http.MethodPost, c.host+"/repos/"+repo+"/deployments", body, http.StatusCreated, &created)
+	return created, err
+}
+
+// ListDeployments lists deployments, following pages until the last one.
+//
+// See: https://docs.github.com/en/rest/deployments/deployments#list-deployments
+func (c *Client) ListDeployments(ctx context.Context, repo string, filter DeploymentFilter) ([]Deployment, error) {
+	query := url.Values{}
+	if filter.Environment != "" {
+		query.Set("environment", filter.Environment)
+	}
+	if filter.Ref != "" {
+		query.Set("ref", filter.Ref)
+	}
+	query.Set("per_page", "100")
+
+	var all []Deployment
+	u := c.host + "/repos/" + repo + "/deployments?" + query.Encode()
+	for u != "" {
+		var dd []Deployment
+		header, err := c.do(ctx, http.MethodGet, u, nil, http.StatusOK, &dd)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, dd...)
+		u = nextPage(header.Get("Link"))
+	}
+	return all, nil
+}
+
+// nextPage returns the URL of the next page from the Link header,
+// or empty string if this is the last page.
+//
+// See: https://docs.github.com/en/rest/guides/using-pagination-in-the-rest-api
+func nextPage(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		segments := strings.Split(part, ";")
+		if len(segments) < 2 {
+			continue
+		}
+		for _, param := range segments[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(segments[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// CreateDeploymentStatus creates a deployment status.
+//
+// See: https://docs.github.com/en/rest/deployments/statuses#create-a-deployment-status
+func (c *Client) CreateDeploymentStatus(ctx context.Context, repo string, deploymentID int64, s DeploymentStatus) error {
+	path := fmt.Sprintf("/repos/%s/deployments/%d/statuses", repo, deploymentID)
+	_, err := c.do(ctx, http.MethodPost, c.host+path, s, http.StatusCreated, nil)
+	return err
+}
+
+// ListDeploymentStatuses lists deployment statuses.
+//
+// See: https://docs.github.com/en/rest/deployments/statuses#list-deployment-statuses
+func (c *Client) ListDeploymentStatuses(ctx context.Context, repo string, deploymentID int64) ([]DeploymentStatus, error) {
+	path := fmt.Sprintf("/repos/%s/deployments/%d/statuses", repo, deploymentID)
+
+	var ss []DeploymentStatus
+	_, err := c.do(ctx, http.MethodGet, c.host+path, nil, http.StatusOK, &ss)
+	return ss, err
+}
+
+// do makes a request to the URL and decodes the response body into out,
+// if set. It returns headers of the response.
+func (c *Client) do(ctx context.Context, method, u string, in interface{}, expectedStatus int, out interface{}) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, errors.Wrap(err, "encode request to json")
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "token "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	// Previews are required by older GitHub Enterprise versions for
+	// in_progress and inactive deployment states.
+	req.Header.Set("Accept", "application/vnd.github.flash-preview+json, application/vnd.github.ant-man-preview+json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		return nil, errors.Errorf("got response %s", resp.Status)
+	}
+
+	if out == nil {
+		return resp.Header, nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, errors.Wrap(err, "decode response body from json")
+	}
+	return resp.Header, nil
+}
+
+// NewClient returns a new GitHub client. If host is empty, DefaultHost
+// is used.
+func NewClient(host, token string) *Client {
+	if host == "" {
+		host = DefaultHost
+	}
+	return &Client{
+		host:   host,
+		token:  token,
+		client: http.DefaultClient,
+	}
+}
@@ -18,19 +18,55 @@ package grafana
 
 import (
 	"context"
-	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/hypnoglow/chronologist/internal/chronologist"
 )
 
+// Chart annotations that allow service owners to target Grafana annotations
+// of their releases without changing Chronologist configuration.
+const (
+	// ChartAnnotationDashboardUID is the chart annotation with the UID of the
+	// dashboard to annotate releases on.
+	ChartAnnotationDashboardUID = "chronologist.io/dashboard-uid"
+
+	// ChartAnnotationPanelID is the chart annotation with the ID of the
+	// panel to annotate releases on. It is only used together with
+	// ChartAnnotationDashboardUID.
+	ChartAnnotationPanelID = "chronologist.io/panel-id"
+
+	// ChartAnnotationExtraTags is the chart annotation with comma-separated
+	// tags to add to annotations of releases, e.g. "team=payments,tier=1".
+	ChartAnnotationExtraTags = "chronologist.io/extra-tags"
+)
+
+// Keys of tags that tell which chronicle owns the annotation.
+const (
+	// tagCluster is the key of the tag with the name of the cluster the
+	// annotation was created by.
+	tagCluster = "cluster"
+
+	// tagTarget is the key of the tag with the name of the Grafana target
+	// the annotation was created for. Annotations of the primary Grafana
+	// have no such tag.
+	tagTarget = "chronologist_target"
+)
+
 // Annotation represents grafana annotation.
+//
+// Annotations without dashboard are organization-wide. Dashboard annotations
+// are shown only on the dashboard, and on the panel if PanelID is set.
 type Annotation struct {
-	ID         int      `json:"id,omitempty"`
-	UNIXMillis int64    `json:"time"`
-	Tags       []string `json:"tags"`
-	Text       string   `json:"text"`
+	ID           int      `json:"id,omitempty"`
+	DashboardID  int      `json:"dashboardId,omitempty"`
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelID      int      `json:"panelId,omitempty"`
+	UNIXMillis   int64    `json:"time"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
 }
 
 // ToReleaseEvent converts the grafana annotation to a chronologist release event.
@@ -70,25 +106,78 @@ func (a Annotation) ToReleaseEvent() chronologist.ReleaseEvent {
 // AnnotationFromEvent assembles a grafana annotation from the chronologist
 // release event.
 func AnnotationFromEvent(id int, re chronologist.ReleaseEvent) Annotation {
+	var tags []string
+	for _, tag := range re.Tags() {
+		tags = append(tags, tag.String())
+	}
+
 	return Annotation{
 		ID:         id,
 		UNIXMillis: re.Time.Unix() * 1000,
-		Tags: []string{
-			"event=release",
-			"heritage=chronologist",
-			"release_type=" + re.Type.String(),
-			"release_status=" + re.Status,
-			"release_name=" + re.Name,
-			"release_revision=" + re.Revision,
-			"release_namespace=" + re.Namespace,
-		},
-		Text: fmt.Sprintf("%s release %s", strings.Title(re.Type.String()), re.Name),
+		Tags:       tags,
+		Text:       re.Summary(),
+	}
+}
+
+// ExtraTags returns tags declared in the chart of the release event.
+// Tags that would clash with tags Chronologist relies on are skipped.
+func ExtraTags(re chronologist.ReleaseEvent) []string {
+	reserved := map[string]bool{tagCluster: true, tagTarget: true}
+	for _, tag := range re.Tags() {
+		reserved[tag.Key] = true
 	}
+
+	var tags []string
+	for _, tag := range strings.Split(re.Chart.Annotations[ChartAnnotationExtraTags], ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || reserved[strings.SplitN(tag, "=", 2)[0]] {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags
 }
 
 // Annotations is a set of grafana annotations.
 type Annotations []Annotation
 
+// OfTarget returns annotations created for the Grafana target, or for the
+// primary Grafana if the target is empty. Grafana cannot query annotations
+// that lack a tag, so they are filtered out after the query.
+func (aa Annotations) OfTarget(target string) Annotations {
+	var result Annotations
+	for _, a := range aa {
+		var t string
+		for _, tag := range a.Tags {
+			if strings.HasPrefix(tag, tagTarget+"=") {
+				t = strings.TrimPrefix(tag, tagTarget+"=")
+			}
+		}
+		if t == target {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// OfCluster returns annotations created by the cluster, or not tagged with
+// any cluster if the cluster is empty.
+func (aa Annotations) OfCluster(cluster string) Annotations {
+	var result Annotations
+	for _, a := range aa {
+		var c string
+		for _, tag := range a.Tags {
+			if strings.HasPrefix(tag, tagCluster+"=") {
+				c = strings.TrimPrefix(tag, tagCluster+"=")
+			}
+		}
+		if c == cluster {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
 // Annotator can manage annotations.
 type Annotator interface {
 	// SaveAnnotation saves annotation, either creating or updating it.
@@ -102,8 +191,102 @@ type Annotator interface {
 }
 
 // GetAnnotationsParams represent query parameters for GetAnnotations.
+// Zero values mean no filtering.
 type GetAnnotationsParams struct {
-	Tags []string
+	// Tags the annotations must have, all of them or, if MatchAny is set,
+	// any of them.
+	Tags     []string
+	MatchAny bool
+
+	// From and To limit the time range of annotations.
+	From time.Time
+	To   time.Time
+
+	// Limit is the maximum number of annotations to return.
+	Limit int
+
+	// Type is either "annotation" or "alert".
+	Type string
+
+	// DashboardUID or DashboardID and PanelID the annotations belong to.
+	DashboardUID string
+	DashboardID  int
+	PanelID      int
+
+	// UserID is the id of the user who created the annotations.
+	UserID int
+}
+
+// Annotation types.
+const (
+	TypeAnnotation = "annotation"
+	TypeAlert      = "alert"
+)
+
+func (p GetAnnotationsParams) query() url.Values {
+	query := url.Values{}
+	if len(p.Tags) > 0 {
+		query["tags"] = p.Tags
+	}
+	if p.MatchAny {
+		query.Set("matchAny", "true")
+	}
+	if !p.From.IsZero() {
+		query.Set("from", strconv.FormatInt(unixMillis(p.From), 10))
+	}
+	if !p.To.IsZero() {
+		query.Set("to", strconv.FormatInt(unixMillis(p.To), 10))
+	}
+	if p.Limit > 0 {
+		query.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Type != "" {
+		query.Set("type", p.Type)
+	}
+	if p.DashboardID != 0 {
+		query.Set("dashboardId", strconv.Itoa(p.DashboardID))
+	} else if p.DashboardUID != "" {
+		query.Set("dashboardUID", p.DashboardUID)
+	}
+	if p.PanelID != 0 {
+		query.Set("panelId", strconv.Itoa(p.PanelID))
+	}
+	if p.UserID != 0 {
+		query.Set("userId", strconv.Itoa(p.UserID))
+	}
+	return query
+}
+
+// matches reports whether the annotation matches the params, as Grafana
+// would filter it.
+func (p GetAnnotationsParams) matches(a Annotation) bool {
+	switch {
+	case p.Type == TypeAlert,
+		!p.From.IsZero() && a.UNIXMillis < unixMillis(p.From),
+		!p.To.IsZero() && a.UNIXMillis > unixMillis(p.To),
+		p.DashboardID != 0 && a.DashboardID != p.DashboardID,
+		p.DashboardID == 0 && p.DashboardUID != "" && a.DashboardUID != p.DashboardUID,
+		p.PanelID != 0 && a.PanelID != p.PanelID:
+		return false
+	}
+
+	if len(p.Tags) == 0 {
+		return true
+	}
+	tags := make(map[string]bool, len(a.Tags))
+	for _, tag := range a.Tags {
+		tags[tag] = true
+	}
+	for _, tag := range p.Tags {
+		if tags[tag] == p.MatchAny {
+			return p.MatchAny
+		}
+	}
+	return !p.MatchAny
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
 }
 
 // ByRelease modifies the params to add a filter by specific release name
@@ -115,3 +298,24 @@ func (p *GetAnnotationsParams) ByRelease(name, revision string) {
 		"release_revision="+revision,
 	)
 }
+
+// ByOwner modifies the params to add a filter by the cluster annotations
+// were created in and the Grafana target they were created for. Empty
+// cluster matches annotations of all clusters. Empty target matches
+// annotations of all targets, so results must be narrowed with
+// Annotations.OfTarget.
+func (p *GetAnnotationsParams) ByOwner(cluster, target string) {
+	if cluster != "" {
+		p.Tags = append(p.Tags, tagCluster+"="+cluster)
+	}
+	if target != "" {
+		p.Tags = append(p.Tags, tagTarget+"="+target)
+	}
+}
+
+// Owned modifies the params to add a filter by annotations created by
+// Chronologist in the cluster for the Grafana target. See ByOwner.
+func (p *GetAnnotationsParams) Owned(cluster, target string) {
+	p.Tags = append(p.Tags, "heritage=chronologist")
+	p.ByOwner(cluster, target)
+}
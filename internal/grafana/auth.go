@@ -0,0 +1,191 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Authorizer authorizes requests to Grafana.
+type Authorizer interface {
+	// Authorize sets credentials on the request.
+	Authorize(req *http.Request)
+}
+
+// BearerToken authorizes requests with a token, which is either an API key
+// or a service account token.
+type BearerToken string
+
+// Authorize implements Authorizer.
+func (t BearerToken) Authorize(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+}
+
+// BasicAuth authorizes requests with username and password.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// Authorize implements Authorizer.
+func (a BasicAuth) Authorize(req *http.Request) {
+	req.SetBasicAuth(a.Username, a.Password)
+}
+
+// tokenFileCheckPeriod is how often token files are checked for changes.
+const tokenFileCheckPeriod = time.Second * 10
+
+// NewTokenFile returns a TokenFile with the token read from the file.
+func NewTokenFile(path string, log *zap.Logger) (*TokenFile, error) {
+	f := &TokenFile{
+		path: path,
+		log:  log,
+	}
+	if _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// TokenFile authorizes requests with a bearer token read from the file,
+// e.g. a mounted Kubernetes secret. The file is checked for changes
+// periodically, so the token can be rotated without restarting. Requests
+// in flight keep the token they were authorized with.
+type TokenFile struct {
+	path string
+	log  *zap.Logger
+
+	token atomic.Value // string
+
+	// modTime and size of the file the token was last read from. They are
+	// only accessed by Reload, which is not called concurrently.
+	modTime time.Time
+	size    int64
+}
+
+// Authorize implements Authorizer.
+func (f *TokenFile) Authorize(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+f.token.Load().(string))
+}
+
+// Reload reads the token from the file if the file has changed since the
+// token was last read. It returns true if the token has been reloaded.
+// On error, the previous token is kept.
+func (f *TokenFile) Reload() (bool, error) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return false, errors.Wrap(err, "stat token file")
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return false, nil
+	}
+
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return false, errors.Wrap(err, "read token file")
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return false, errors.Errorf("token file %s is empty", f.path)
+	}
+
+	f.token.Store(token)
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	return true, nil
+}
+
+// Run checks the token file for changes until stopCh is closed.
+func (f *TokenFile) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(tokenFileCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			reloaded, err := f.Reload()
+			if err != nil {
+				f.log.Sugar().Errorf("Failed to reload Grafana token, keep using the previous one: %s", err)
+				continue
+			}
+			if reloaded {
+				f.log.Sugar().Infof("Reloaded Grafana token from %s", f.path)
+			}
+		}
+	}
+}
+
+// Credentials hold Grafana credentials of one of the kinds: API key, service
+// account token, username and password, or a file with a token. The token
+// file is reloaded on changes, see TokenFile.
+type Credentials struct {
+	APIKey              string `json:"apiKey,omitempty"`
+	ServiceAccountToken string `json:"serviceAccountToken,omitempty"`
+	Username            string `json:"username,omitempty"`
+	Password            string `json:"password,omitempty"`
+	TokenFile           string `json:"tokenFile,omitempty"`
+}
+
+// Authorizer returns the authorizer for the credentials. It returns nil
+// if no credentials are set, and an error if more than one kind of
+// credentials is set. The log is used by TokenFile.
+func (c Credentials) Authorizer(log *zap.Logger) (Authorizer, error) {
+	var kinds []string
+	if c.APIKey != "" {
+		kinds = append(kinds, "api key")
+	}
+	if c.ServiceAccountToken != "" {
+		kinds = append(kinds, "service account token")
+	}
+	if c.Username != "" || c.Password != "" {
+		kinds = append(kinds, "basic auth")
+	}
+	if c.TokenFile != "" {
+		kinds = append(kinds, "token file")
+	}
+	if len(kinds) > 1 {
+		return nil, errors.Errorf("only one kind of credentials can be set, got %s", strings.Join(kinds, ", "))
+	}
+
+	switch {
+	case c.APIKey != "":
+		return BearerToken(c.APIKey), nil
+	case c.ServiceAccountToken != "":
+		return BearerToken(c.ServiceAccountToken), nil
+	case c.Username != "" || c.Password != "":
+		return BasicAuth{Username: c.Username, Password: c.Password}, nil
+	case c.TokenFile != "":
+		f, err := NewTokenFile(c.TokenFile, log)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, nil
+	}
+}
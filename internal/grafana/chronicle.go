@@ -18,6 +18,7 @@ package grafana
 
 import (
 	"context"
+	"sync"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -27,11 +28,37 @@ import (
 	"github.com/hypnoglow/chronologist/internal/zaplog"
 )
 
+// Options represent Grafana chronicle options.
+type Options struct {
+	// Selector selects release events the chronicle annotates. Annotations
+	// of release events that do not match the selector are removed, so
+	// release events can move between chronicles of different Grafana
+	// instances or organizations.
+	Selector Selector
+
+	// Routes direct annotations to dashboards and panels. Annotations of
+	// release events that match no route are organization-wide.
+	Routes Routes
+
+	// Cluster is the name of the cluster Chronologist runs in. When set,
+	// annotations are tagged with it, and only annotations of this cluster
+	// are synced, so clusters with the same releases can share Grafana.
+	Cluster string
+
+	// Target is the name of the Grafana target the chronicle annotates
+	// release events in. When set, annotations are tagged with it, so
+	// targets sharing a Grafana organization manage their annotations
+	// independently. It is empty for the primary Grafana.
+	Target string
+}
+
 // NewChronicle returns a new Grafana chronicle.
-func NewChronicle(grafana Annotator, log *zap.Logger) *Chronicle {
+func NewChronicle(grafana Annotator, log *zap.Logger, opts Options) *Chronicle {
 	return &Chronicle{
-		grafana: grafana,
-		log:     log,
+		grafana:  grafana,
+		log:      log,
+		opts:     opts,
+		disowned: make(map[string]bool),
 	}
 }
 
@@ -39,6 +66,13 @@ func NewChronicle(grafana Annotator, log *zap.Logger) *Chronicle {
 type Chronicle struct {
 	grafana Annotator
 	log     *zap.Logger
+	opts    Options
+
+	mx sync.Mutex
+	// disowned holds release revisions that do not match the selector and
+	// are known to have no annotations, so resyncs do not query Grafana
+	// for them again.
+	disowned map[string]bool
 }
 
 // Register adds the release event to the chronicle, syncing it with a corresponding
@@ -46,25 +80,56 @@ type Chronicle struct {
 func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
 	log := zaplog.Grasp(ctx, c.log)
 
-	q := GetAnnotationsParams{}
-	q.ByRelease(re.Name, re.Revision)
+	key := re.Name + "/" + re.Revision
+
+	if !c.opts.Selector.Matches(re) {
+		c.mx.Lock()
+		disowned := c.disowned[key]
+		c.mx.Unlock()
+		if disowned {
+			return nil
+		}
+
+		log.Debug("Release event is not selected for this Grafana, removing its annotations if any")
+		if err := c.Unregister(ctx, re.Name, re.Revision); err != nil {
+			return err
+		}
+
+		c.mx.Lock()
+		c.disowned[key] = true
+		c.mx.Unlock()
+		return nil
+	}
+
+	c.mx.Lock()
+	delete(c.disowned, key)
+	c.mx.Unlock()
 
-	grafanaAnns, err := c.grafana.GetAnnotations(ctx, q)
+	grafanaAnns, err := c.releaseAnnotations(ctx, re.Name, re.Revision)
 	if err != nil {
 		return errors.Wrap(err, "get annotations from grafana")
 	}
 
 	if len(grafanaAnns) > 1 {
-		log.Sugar().Warnf("Found %d annotations for the release event. Sync logic for this case is not implemented", len(grafanaAnns))
-		// TODO: implement sync logic.
-		return nil
+		log.Sugar().Warnf("Found %d annotations for the release event. Keeping one and deleting the rest", len(grafanaAnns))
+		var errs []error
+		for _, a := range grafanaAnns[1:] {
+			log.Sugar().Debugf("Delete duplicate Grafana annotation id=%d", a.ID)
+			if err := c.grafana.DeleteAnnotation(ctx, a.ID); err != nil && !IsNotFound(err) {
+				errs = append(errs, err)
+			}
+		}
+		if err = problems.NewAggregate(errs); err != nil {
+			return errors.Wrap(err, "delete duplicate annotations in grafana")
+		}
+		grafanaAnns = grafanaAnns[:1]
 	}
 
 	if len(grafanaAnns) < 1 {
 		log.Debug("No annotations found for the release event. Creating a new one")
 		err = c.grafana.SaveAnnotation(
 			ctx,
-			AnnotationFromEvent(0, re),
+			c.annotationFromEvent(0, re),
 		)
 		return errors.Wrap(err, "create annotation in grafana")
 	}
@@ -74,20 +139,40 @@ func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent)
 
 	log.Debug("Found one Grafana annotation for the release event. Comparing data")
 
+	route := c.route(re)
+	if grafanaAnns[0].DashboardUID != route.DashboardUID || grafanaAnns[0].PanelID != route.PanelID {
+		// Grafana does not allow to change dashboard of an existing
+		// annotation, so it has to be recreated.
+		log.Sugar().Debugf(
+			"Grafana annotation is routed to dashboard %q panel %d instead of dashboard %q panel %d. Moving annotation",
+			grafanaAnns[0].DashboardUID, grafanaAnns[0].PanelID, route.DashboardUID, route.PanelID,
+		)
+		if err = c.grafana.DeleteAnnotation(ctx, grafanaAnns[0].ID); err != nil && !IsNotFound(err) {
+			return errors.Wrap(err, "delete annotation in grafana")
+		}
+		err = c.grafana.SaveAnnotation(
+			ctx,
+			c.annotationFromEvent(0, re),
+		)
+		return errors.Wrap(err, "create annotation in grafana")
+	}
+
 	re2 := grafanaAnns[0].ToReleaseEvent()
+	ann := c.annotationFromEvent(grafanaAnns[0].ID, re)
 
 	diffs := re.Differences(re2)
-	if len(diffs) == 0 {
+	if len(diffs) == 0 && chronologist.SameTags(grafanaAnns[0].Tags, ann.Tags) {
 		log.Debug("Grafana annotation correctly reflects the release event, sync is not required")
 		return nil
 	}
 
-	log.Sugar().Debugf("Found differences: %v. Syncing annotation in Grafana", diffs)
+	if len(diffs) > 0 {
+		log.Sugar().Debugf("Found differences: %v. Syncing annotation in Grafana", diffs)
+	} else {
+		log.Debug("Found differences in annotation tags. Syncing annotation in Grafana")
+	}
 
-	err = c.grafana.SaveAnnotation(
-		ctx,
-		AnnotationFromEvent(grafanaAnns[0].ID, re),
-	)
+	err = c.grafana.SaveAnnotation(ctx, ann)
 	if err != nil {
 		return errors.Wrap(err, "create annotation")
 	}
@@ -99,12 +184,13 @@ func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent)
 func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
 	log := zaplog.Grasp(ctx, c.log)
 
-	q := GetAnnotationsParams{}
-	q.ByRelease(name, revision)
+	c.mx.Lock()
+	delete(c.disowned, name+"/"+revision)
+	c.mx.Unlock()
 
 	log.Sugar().Debugf("Deleting Grafana annotations related to the release event")
 
-	aa, err := c.grafana.GetAnnotations(ctx, q)
+	aa, err := c.releaseAnnotations(ctx, name, revision)
 	if err != nil {
 		return err
 	}
@@ -112,10 +198,65 @@ func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error
 	var errs []error
 	for _, a := range aa {
 		log.Sugar().Debugf("Delete Grafana annotation id=%d", a.ID)
-		if err := c.grafana.DeleteAnnotation(ctx, a.ID); err != nil {
+		// Annotation could have been deleted in the meantime.
+		if err := c.grafana.DeleteAnnotation(ctx, a.ID); err != nil && !IsNotFound(err) {
 			errs = append(errs, err)
 		}
 	}
 
 	return problems.NewAggregate(errs)
 }
+
+// releaseAnnotations returns annotations of the release revision owned by
+// the chronicle. If the chronicle has a cluster but owns no annotations,
+// annotations of the release revision that are not tagged with any cluster
+// are returned, so they are adopted instead of duplicated after the cluster
+// is configured.
+func (c *Chronicle) releaseAnnotations(ctx context.Context, name, revision string) (Annotations, error) {
+	q := GetAnnotationsParams{}
+	q.ByRelease(name, revision)
+	q.ByOwner(c.opts.Cluster, c.opts.Target)
+
+	aa, err := c.grafana.GetAnnotations(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	aa = aa.OfTarget(c.opts.Target)
+	if len(aa) > 0 || c.opts.Cluster == "" {
+		return aa, nil
+	}
+
+	q = GetAnnotationsParams{}
+	q.ByRelease(name, revision)
+	q.ByOwner("", c.opts.Target)
+
+	aa, err = c.grafana.GetAnnotations(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return aa.OfTarget(c.opts.Target).OfCluster(""), nil
+}
+
+// annotationFromEvent assembles a grafana annotation from the chronologist
+// release event, routing it to the dashboard and panel, and adding extra
+// tags declared in the chart and the tags of the owner.
+func (c *Chronicle) annotationFromEvent(id int, re chronologist.ReleaseEvent) Annotation {
+	a := AnnotationFromEvent(id, re)
+	a.Tags = append(a.Tags, ExtraTags(re)...)
+	if c.opts.Cluster != "" {
+		a.Tags = append(a.Tags, tagCluster+"="+c.opts.Cluster)
+	}
+	if c.opts.Target != "" {
+		a.Tags = append(a.Tags, tagTarget+"="+c.opts.Target)
+	}
+
+	route := c.route(re)
+	a.DashboardUID = route.DashboardUID
+	a.PanelID = route.PanelID
+	return a
+}
+
+// route returns the route of the release event.
+func (c *Chronicle) route(re chronologist.ReleaseEvent) Route {
+	return routeOf(c.opts.Routes, re)
+}
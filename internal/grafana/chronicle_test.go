@@ -2,15 +2,18 @@ package grafana_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gojuno/minimock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"github.com/hypnoglow/chronologist/internal/chronologist"
 	"github.com/hypnoglow/chronologist/internal/grafana"
+	"github.com/hypnoglow/chronologist/internal/grafana/fake"
 	"github.com/hypnoglow/chronologist/internal/grafana/mocks"
 )
 
@@ -47,7 +50,56 @@ func TestChronicle_Register_createAnnotation(t *testing.T) {
 		}).
 		Return(nil)
 
-	cr := grafana.NewChronicle(ann, zap.NewNop())
+	cr := grafana.NewChronicle(ann, zap.NewNop(), grafana.Options{})
+
+	err := cr.Register(context.Background(), re)
+	assert.NoError(t, err)
+}
+
+// Tests that chronicle creates a new annotation for the release on the
+// dashboard declared in the chart.
+func TestChronicle_Register_createAnnotationFromChart(t *testing.T) {
+	mc := minimock.NewController(t)
+	defer mc.Finish()
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+		Chart: chronologist.Chart{
+			Annotations: map[string]string{
+				"chronologist.io/dashboard-uid": "foo",
+				"chronologist.io/extra-tags":    "team=payments, tier=1,release_name=bar",
+			},
+		},
+	}
+
+	ann := mocks.NewAnnotatorMock(t)
+	ann.GetAnnotationsMock.
+		Expect(context.Background(), grafana.GetAnnotationsParams{
+			Tags: []string{
+				"heritage=chronologist",
+				"release_name=foo",
+				"release_revision=1",
+			},
+		}).
+		Return(grafana.Annotations{}, nil)
+	ann.SaveAnnotationMock.
+		Expect(context.Background(), grafana.Annotation{
+			ID:           0,
+			DashboardUID: "foo",
+			UNIXMillis:   1546441445000,
+			Tags:         []string{"event=release", "heritage=chronologist", "release_type=rollout", "release_status=DEPLOYED", "release_name=foo", "release_revision=1", "release_namespace=default", "team=payments", "tier=1"},
+			Text:         "Rollout release foo",
+		}).
+		Return(nil)
+
+	cr := grafana.NewChronicle(ann, zap.NewNop(), grafana.Options{
+		Routes: grafana.Routes{{DashboardUID: "all"}},
+	})
 
 	err := cr.Register(context.Background(), re)
 	assert.NoError(t, err)
@@ -84,7 +136,7 @@ func TestChronicle_Register_skipAnnotation(t *testing.T) {
 			Text:       "Rollout release foo",
 		}}, nil)
 
-	cr := grafana.NewChronicle(ann, zap.NewNop())
+	cr := grafana.NewChronicle(ann, zap.NewNop(), grafana.Options{})
 
 	err := cr.Register(context.Background(), re)
 	assert.NoError(t, err)
@@ -129,12 +181,116 @@ func TestChronicle_Register_updateAnnotation(t *testing.T) {
 		}).
 		Return(nil)
 
-	cr := grafana.NewChronicle(ann, zap.NewNop())
+	cr := grafana.NewChronicle(ann, zap.NewNop(), grafana.Options{})
+
+	err := cr.Register(context.Background(), re)
+	assert.NoError(t, err)
+}
+
+// Test that chronicle moves the release annotation to another dashboard
+// because routing has changed.
+func TestChronicle_Register_moveAnnotation(t *testing.T) {
+	mc := minimock.NewController(t)
+	defer mc.Finish()
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+		Labels:    map[string]string{"team": "core"},
+	}
+
+	ann := mocks.NewAnnotatorMock(t)
+	ann.GetAnnotationsMock.
+		Expect(context.Background(), grafana.GetAnnotationsParams{
+			Tags: []string{
+				"heritage=chronologist",
+				"release_name=foo",
+				"release_revision=1",
+			},
+		}).
+		Return(grafana.Annotations{{
+			ID:         123,
+			UNIXMillis: 1546441445000,
+			Tags:       []string{"event=release", "heritage=chronologist", "release_type=rollout", "release_status=DEPLOYED", "release_name=foo", "release_revision=1", "release_namespace=default"},
+			Text:       "Rollout release foo",
+		}}, nil)
+	ann.DeleteAnnotationMock.
+		Expect(context.Background(), 123).
+		Return(nil)
+	ann.SaveAnnotationMock.
+		Expect(context.Background(), grafana.Annotation{
+			ID:           0,
+			DashboardUID: "core",
+			PanelID:      2,
+			UNIXMillis:   1546441445000,
+			Tags:         []string{"event=release", "heritage=chronologist", "release_type=rollout", "release_status=DEPLOYED", "release_name=foo", "release_revision=1", "release_namespace=default"},
+			Text:         "Rollout release foo",
+		}).
+		Return(nil)
+
+	cr := grafana.NewChronicle(ann, zap.NewNop(), grafana.Options{
+		Routes: grafana.Routes{
+			{Selector: grafana.Selector{Namespace: "kube-*"}, DashboardUID: "kube"},
+			{Selector: grafana.Selector{Labels: map[string]string{"team": "core"}}, DashboardUID: "core", PanelID: 2},
+		},
+	})
 
 	err := cr.Register(context.Background(), re)
 	assert.NoError(t, err)
 }
 
+// Test that chronicle removes the release annotation because the release
+// event is not selected anymore, and does not look for it again on resync.
+func TestChronicle_Register_disownAnnotation(t *testing.T) {
+	mc := minimock.NewController(t)
+	defer mc.Finish()
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	ann := mocks.NewAnnotatorMock(t)
+	ann.GetAnnotationsMock.
+		Expect(context.Background(), grafana.GetAnnotationsParams{
+			Tags: []string{
+				"heritage=chronologist",
+				"release_name=foo",
+				"release_revision=1",
+			},
+		}).
+		Return(grafana.Annotations{{
+			ID:         123,
+			UNIXMillis: 1546441445000,
+			Tags:       []string{"event=release", "heritage=chronologist", "release_type=rollout", "release_status=DEPLOYED", "release_name=foo", "release_revision=1", "release_namespace=default"},
+			Text:       "Rollout release foo",
+		}}, nil)
+	ann.DeleteAnnotationMock.
+		Expect(context.Background(), 123).
+		Return(nil)
+
+	cr := grafana.NewChronicle(ann, zap.NewNop(), grafana.Options{
+		Selector: grafana.Selector{Namespace: "payments-*"},
+	})
+
+	err := cr.Register(context.Background(), re)
+	assert.NoError(t, err)
+
+	err = cr.Register(context.Background(), re)
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint64(1), ann.GetAnnotationsMinimockCounter())
+	assert.Equal(t, uint64(1), ann.DeleteAnnotationMinimockCounter())
+}
+
 func TestChronicle_Unregister(t *testing.T) {
 	mc := minimock.NewController(t)
 	defer mc.Finish()
@@ -167,8 +323,249 @@ func TestChronicle_Unregister(t *testing.T) {
 		Expect(context.Background(), 123).
 		Return(nil)
 
-	cr := grafana.NewChronicle(ann, zap.NewNop())
+	cr := grafana.NewChronicle(ann, zap.NewNop(), grafana.Options{})
 
 	err := cr.Unregister(context.Background(), re.Name, re.Revision)
 	assert.NoError(t, err)
 }
+
+// Tests that chronicle of a Grafana target looks up and tags annotations
+// of the target only.
+func TestChronicle_Register_target(t *testing.T) {
+	mc := minimock.NewController(t)
+	defer mc.Finish()
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	ann := mocks.NewAnnotatorMock(t)
+	ann.GetAnnotationsMock.
+		Expect(context.Background(), grafana.GetAnnotationsParams{
+			Tags: []string{
+				"heritage=chronologist",
+				"release_name=foo",
+				"release_revision=1",
+				"chronologist_target=payments",
+			},
+		}).
+		Return(grafana.Annotations{}, nil)
+	ann.SaveAnnotationMock.
+		Expect(context.Background(), grafana.Annotation{
+			ID:         0,
+			UNIXMillis: 1546441445000,
+			Tags:       []string{"event=release", "heritage=chronologist", "release_type=rollout", "release_status=DEPLOYED", "release_name=foo", "release_revision=1", "release_namespace=default", "chronologist_target=payments"},
+			Text:       "Rollout release foo",
+		}).
+		Return(nil)
+
+	cr := grafana.NewChronicle(ann, zap.NewNop(), grafana.Options{Target: "payments"})
+
+	err := cr.Register(context.Background(), re)
+	assert.NoError(t, err)
+}
+
+// Tests that clusters sharing Grafana sync annotations of the same release
+// revision independently.
+func TestChronicle_clusters(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	ctx := context.Background()
+	prod := grafana.NewChronicle(client, zap.NewNop(), grafana.Options{Cluster: "prod"})
+	staging := grafana.NewChronicle(client, zap.NewNop(), grafana.Options{Cluster: "staging"})
+
+	require.NoError(t, prod.Register(ctx, re))
+	require.NoError(t, staging.Register(ctx, re))
+
+	// Resyncs change nothing.
+	re2 := re
+	re2.Status = "SUPERSEDED"
+	require.NoError(t, prod.Register(ctx, re2))
+	require.NoError(t, staging.Register(ctx, re))
+
+	statuses := func() map[string]string {
+		m := make(map[string]string)
+		for _, a := range srv.Annotations() {
+			var cluster string
+			for _, tag := range a.Tags {
+				if strings.HasPrefix(tag, "cluster=") {
+					cluster = strings.TrimPrefix(tag, "cluster=")
+				}
+			}
+			m[cluster] = a.ToReleaseEvent().Status
+		}
+		return m
+	}
+	require.Len(t, srv.Annotations(), 2)
+	assert.Equal(t, map[string]string{"prod": "SUPERSEDED", "staging": "DEPLOYED"}, statuses())
+
+	require.NoError(t, prod.Unregister(ctx, re.Name, re.Revision))
+	assert.Equal(t, map[string]string{"staging": "DEPLOYED"}, statuses())
+}
+
+// Tests that a chronicle with a cluster adopts annotations created before
+// the cluster was configured, keeping one of duplicates and leaving
+// annotations of other clusters alone.
+func TestChronicle_adoptAnnotations(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	staging := grafana.AnnotationFromEvent(0, re)
+	staging.Tags = append(staging.Tags, "cluster=staging")
+	srv.AddAnnotation(staging)
+	srv.AddAnnotation(grafana.AnnotationFromEvent(0, re))
+	srv.AddAnnotation(grafana.AnnotationFromEvent(0, re))
+
+	re.Status = "SUPERSEDED"
+	cr := grafana.NewChronicle(client, zap.NewNop(), grafana.Options{Cluster: "prod"})
+	require.NoError(t, cr.Register(context.Background(), re))
+
+	aa := srv.Annotations()
+	require.Len(t, aa, 2)
+	assert.Len(t, aa.OfCluster("staging"), 1)
+	require.Len(t, aa.OfCluster("prod"), 1)
+	assert.Equal(t, "SUPERSEDED", aa.OfCluster("prod")[0].ToReleaseEvent().Status)
+}
+
+// Tests that Grafana targets sharing an organization manage annotations
+// of the release events they select independently, whether selectors of
+// the targets overlap or not.
+func TestChronicle_targets(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	newChronicle := func(target, namespace string) *grafana.Chronicle {
+		return grafana.NewChronicle(client, zap.NewNop(), grafana.Options{
+			Selector: grafana.Selector{Namespace: namespace},
+			Target:   target,
+		})
+	}
+	chronicles := []*grafana.Chronicle{
+		newChronicle("payments", "payments"),
+		newChronicle("search", "search"),
+		// The primary Grafana has no target name.
+		newChronicle("", ""),
+	}
+
+	events := []chronologist.ReleaseEvent{
+		{
+			Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+			Type:      chronologist.ReleaseTypeRollout,
+			Status:    "DEPLOYED",
+			Name:      "foo",
+			Revision:  "1",
+			Namespace: "payments",
+		},
+		{
+			Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+			Type:      chronologist.ReleaseTypeRollout,
+			Status:    "DEPLOYED",
+			Name:      "bar",
+			Revision:  "1",
+			Namespace: "search",
+		},
+	}
+
+	ctx := context.Background()
+	register := func() {
+		for _, re := range events {
+			for _, cr := range chronicles {
+				require.NoError(t, cr.Register(ctx, re))
+			}
+		}
+	}
+
+	annotated := func() []string {
+		var result []string
+		for _, a := range srv.Annotations() {
+			target := "primary"
+			for _, tag := range a.Tags {
+				if strings.HasPrefix(tag, "chronologist_target=") {
+					target = strings.TrimPrefix(tag, "chronologist_target=")
+				}
+			}
+			result = append(result, target+"/"+a.ToReleaseEvent().Name)
+		}
+		return result
+	}
+
+	register()
+	// Resyncs, e.g. after restart, change nothing.
+	events[0].Status = "SUPERSEDED"
+	register()
+
+	assert.ElementsMatch(t, []string{"payments/foo", "search/bar", "primary/foo", "primary/bar"}, annotated())
+	for _, a := range srv.Annotations() {
+		re := a.ToReleaseEvent()
+		if re.Name == "foo" {
+			assert.Equal(t, "SUPERSEDED", re.Status)
+		}
+	}
+
+	require.NoError(t, chronicles[0].Unregister(ctx, "foo", "1"))
+	assert.ElementsMatch(t, []string{"search/bar", "primary/foo", "primary/bar"}, annotated())
+
+	require.NoError(t, chronicles[2].Unregister(ctx, "bar", "1"))
+	assert.ElementsMatch(t, []string{"search/bar", "primary/foo"}, annotated())
+}
+
+// Tests that the dashboard URL of a release event points to the dashboard
+// it is annotated on, around the time of the release event.
+func TestDashboardURL(t *testing.T) {
+	routes := grafana.Routes{
+		{Selector: grafana.Selector{Namespace: "payments"}, DashboardUID: "pay", PanelID: 2},
+	}
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Name:      "foo",
+		Namespace: "payments",
+	}
+
+	assert.Equal(t,
+		"http://grafana/d/pay?from=1546437845000&to=1546445045000&viewPanel=2",
+		grafana.DashboardURL("http://grafana/", routes, re),
+	)
+
+	re.Chart.Annotations = map[string]string{"chronologist.io/dashboard-uid": "foo"}
+	assert.Equal(t,
+		"http://grafana/d/foo?from=1546437845000&to=1546445045000",
+		grafana.DashboardURL("http://grafana", routes, re),
+	)
+
+	re.Chart.Annotations = nil
+	re.Namespace = "default"
+	assert.Empty(t, grafana.DashboardURL("http://grafana", routes, re))
+}
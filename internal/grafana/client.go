@@ -19,24 +19,45 @@ package grafana
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 const basePath = "/api"
 
+// annotationsPageSize is the number of annotations requested at once.
+const annotationsPageSize = 1000
+
 // Client is a grafana HTTP API client.
 //
 // Client implements Annotator interface using grafana HTTP API.
 type Client struct {
-	host   string
-	apiKey string
-
-	client *http.Client
+	host  string
+	auth  Authorizer
+	orgID int64
+
+	client  *http.Client
+	limiter *limiter
+	breaker *breaker
+	retry   RetryOptions
+
+	mx sync.Mutex
+	// version of Grafana, if detected.
+	version Version
+	// dashboardIDs caches dashboard ids by uids. Older Grafana versions
+	// only know dashboards by ids in annotations API.
+	dashboardIDs map[string]int
 }
 
 // SaveAnnotation saves annotation to grafana, either creating or updating it.
@@ -52,7 +73,15 @@ func (c *Client) SaveAnnotation(ctx context.Context, annotation Annotation) erro
 }
 
 func (c *Client) createAnnotation(ctx context.Context, annotation Annotation) error {
-	b, err := json.Marshal(annotation)
+	if annotation.DashboardUID != "" && annotation.DashboardID == 0 && !c.Version().AtLeast(versionAnnotationDashboardUID) {
+		id, err := c.dashboardID(ctx, annotation.DashboardUID)
+		if err != nil {
+			return errors.Wrapf(err, "get id of dashboard %s", annotation.DashboardUID)
+		}
+		annotation.DashboardID = id
+	}
+
+	b, err := json.Marshal(c.annotationRequest(annotation))
 	if err != nil {
 		return errors.Wrap(err, "encode request to json")
 	}
@@ -64,21 +93,21 @@ func (c *Client) createAnnotation(ctx context.Context, annotation Annotation) er
 	}
 	req = c.enrichRequest(ctx, req)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return errors.Wrap(err, "do request")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("got response %s", resp.Status)
+		return newAPIError(req, resp)
 	}
 
 	return nil
 }
 
 func (c *Client) updateAnnotation(ctx context.Context, annotation Annotation) error {
-	b, err := json.Marshal(annotation)
+	b, err := json.Marshal(c.annotationRequest(annotation))
 	if err != nil {
 		return errors.Wrap(err, "encode request to json")
 	}
@@ -90,43 +119,124 @@ func (c *Client) updateAnnotation(ctx context.Context, annotation Annotation) er
 	}
 	req = c.enrichRequest(ctx, req)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return errors.Wrap(err, "do request")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("got response %s", resp.Status)
+		return newAPIError(req, resp)
 	}
 
 	return nil
 }
 
+// annotationRequest is the body of requests that save annotations.
+type annotationRequest struct {
+	Annotation
+
+	// TimeEnd is the end of the annotation, the same as the time for point
+	// annotations. It is only sent to Grafana versions that need it.
+	TimeEnd int64 `json:"timeEnd,omitempty"`
+}
+
+func (c *Client) annotationRequest(annotation Annotation) annotationRequest {
+	r := annotationRequest{Annotation: annotation}
+	if c.Version().AtLeast(versionAnnotationTimeEnd) {
+		r.TimeEnd = annotation.UNIXMillis
+	}
+	return r
+}
+
 // GetAnnotations fetches annotations from grafana using optional query params.
 //
+// Grafana limits the number of annotations in a response, so annotations
+// are fetched in pages, moving the end of the time range to the oldest
+// annotation of the previous page, until in.Limit annotations are fetched,
+// or all of them if in.Limit is zero. It fails rather than returns a part of
+// the annotations if more of them than fit in a page share the same time.
+//
 // See: http://docs.grafana.org/v4.6/http_api/annotations/#find-annotations
 func (c *Client) GetAnnotations(ctx context.Context, in GetAnnotationsParams) (Annotations, error) {
-	query := url.Values{}
-	if len(in.Tags) > 0 {
-		query["tags"] = in.Tags
+	if in.DashboardUID != "" && in.DashboardID == 0 && !c.Version().AtLeast(versionAnnotationDashboardUID) {
+		// Older Grafana versions can only filter by dashboard id.
+		id, err := c.dashboardID(ctx, in.DashboardUID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get id of dashboard %s", in.DashboardUID)
+		}
+		in.DashboardID = id
+	}
+
+	pageSize := annotationsPageSize
+	if in.Limit > 0 && in.Limit < pageSize {
+		pageSize = in.Limit
+	}
+
+	var aa Annotations
+	seen := make(map[int]bool)
+	page := in
+	page.Limit = pageSize
+	for {
+		pa, err := c.getAnnotationsPage(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for _, a := range pa {
+			if seen[a.ID] {
+				continue
+			}
+			seen[a.ID] = true
+			aa = append(aa, a)
+			added++
+			if len(aa) == in.Limit {
+				return aa, nil
+			}
+		}
+
+		if len(pa) < pageSize {
+			return aa, nil
+		}
+
+		// Time range end is inclusive, so the next page repeats annotations
+		// at the oldest time of this page. If a full page adds nothing new,
+		// more annotations than fit in a page share the same time, and there
+		// is no way to get the rest.
+		if added == 0 {
+			return nil, errors.Errorf(
+				"more than %d annotations share time %d, cannot fetch all of them",
+				pageSize, pa[0].UNIXMillis,
+			)
+		}
+
+		oldest := pa[0].UNIXMillis
+		for _, a := range pa {
+			if a.UNIXMillis < oldest {
+				oldest = a.UNIXMillis
+			}
+		}
+		page.To = time.Unix(0, oldest*int64(time.Millisecond))
 	}
+}
 
-	u := fmt.Sprintf("%s%s%s?%s", c.host, basePath, "/annotations", query.Encode())
+func (c *Client) getAnnotationsPage(ctx context.Context, in GetAnnotationsParams) (Annotations, error) {
+	u := fmt.Sprintf("%s%s%s?%s", c.host, basePath, "/annotations", in.query().Encode())
 	req, err := http.NewRequest(http.MethodGet, u, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "create request")
 	}
 	req = c.enrichRequest(ctx, req)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "do request")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("got response %s", resp.Status)
+		return nil, newAPIError(req, resp)
 	}
 
 	var aa Annotations
@@ -134,9 +244,118 @@ func (c *Client) GetAnnotations(ctx context.Context, in GetAnnotationsParams) (A
 		return nil, errors.Wrap(err, "decode response body from json")
 	}
 
+	for i, a := range aa {
+		if a.DashboardID == 0 || a.DashboardUID != "" {
+			continue
+		}
+		uid, err := c.dashboardUID(ctx, a.DashboardID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get uid of dashboard %d", a.DashboardID)
+		}
+		aa[i].DashboardUID = uid
+	}
+
 	return aa, nil
 }
 
+// dashboardID returns id of the dashboard by its uid.
+//
+// See: http://docs.grafana.org/v5.0/http_api/dashboard/#get-dashboard-by-uid
+func (c *Client) dashboardID(ctx context.Context, uid string) (int, error) {
+	c.mx.Lock()
+	id, ok := c.dashboardIDs[uid]
+	c.mx.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	u := fmt.Sprintf("%s%s/dashboards/uid/%s", c.host, basePath, url.PathEscape(uid))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "create request")
+	}
+	req = c.enrichRequest(ctx, req)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, newAPIError(req, resp)
+	}
+
+	var body struct {
+		Dashboard struct {
+			ID int `json:"id"`
+		} `json:"dashboard"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, errors.Wrap(err, "decode response body from json")
+	}
+
+	c.rememberDashboard(uid, body.Dashboard.ID)
+	return body.Dashboard.ID, nil
+}
+
+// dashboardUID returns uid of the dashboard by its id, or an empty string
+// if there is no such dashboard.
+//
+// See: http://docs.grafana.org/v5.0/http_api/folder_dashboard_search/
+func (c *Client) dashboardUID(ctx context.Context, id int) (string, error) {
+	c.mx.Lock()
+	for uid, knownID := range c.dashboardIDs {
+		if knownID == id {
+			c.mx.Unlock()
+			return uid, nil
+		}
+	}
+	c.mx.Unlock()
+
+	query := url.Values{}
+	query.Set("dashboardIds", strconv.Itoa(id))
+
+	u := fmt.Sprintf("%s%s/search?%s", c.host, basePath, query.Encode())
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "create request")
+	}
+	req = c.enrichRequest(ctx, req)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError(req, resp)
+	}
+
+	var hits []struct {
+		ID  int    `json:"id"`
+		UID string `json:"uid"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&hits); err != nil {
+		return "", errors.Wrap(err, "decode response body from json")
+	}
+
+	for _, h := range hits {
+		if h.ID == id {
+			c.rememberDashboard(h.UID, h.ID)
+			return h.UID, nil
+		}
+	}
+	return "", nil
+}
+
+func (c *Client) rememberDashboard(uid string, id int) {
+	c.mx.Lock()
+	c.dashboardIDs[uid] = id
+	c.mx.Unlock()
+}
+
 // DeleteAnnotation deletes annotation from grafana by its id.
 //
 // See: http://docs.grafana.org/v4.6/http_api/annotations/#delete-annotation-by-id
@@ -148,14 +367,14 @@ func (c *Client) DeleteAnnotation(ctx context.Context, id int) error {
 	}
 	req = c.enrichRequest(ctx, req)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return errors.Wrap(err, "do request")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("got response %s", resp.Status)
+		return newAPIError(req, resp)
 	}
 
 	return nil
@@ -163,16 +382,124 @@ func (c *Client) DeleteAnnotation(ctx context.Context, id int) error {
 
 func (c *Client) enrichRequest(ctx context.Context, req *http.Request) *http.Request {
 	req = req.WithContext(ctx)
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if c.auth != nil {
+		c.auth.Authorize(req)
+	}
+	if c.orgID != 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.FormatInt(c.orgID, 10))
+	}
 	req.Header.Set("Content-Type", "application/json")
 	return req
 }
 
+// ClientOptions represent grafana client options.
+type ClientOptions struct {
+	// OrgID is the organization requests are made in. When zero, the
+	// organization of the credentials is used.
+	OrgID int64
+
+	// Auth authorizes requests. When nil, requests are anonymous.
+	Auth Authorizer
+
+	// CAFile is a path to the PEM-encoded CA bundle to verify Grafana
+	// certificate with. When empty, system CA bundle is used.
+	CAFile string
+
+	// CertFile and KeyFile are paths to the PEM-encoded client certificate
+	// and key for mutual TLS.
+	CertFile string
+	KeyFile  string
+
+	// ProxyURL is the proxy to make requests through. When empty, proxy
+	// is taken from environment variables.
+	ProxyURL string
+
+	// Timeout limits the time of a request attempt. Zero means no timeout.
+	Timeout time.Duration
+
+	// RateLimit limits the number of requests per second, allowing bursts
+	// of RateBurst requests. Zero means no limit.
+	RateLimit float64
+	RateBurst int
+
+	// Retry configures retries of transient failures: network errors and
+	// 502, 503, 504 responses for idempotent requests, and 429 and 503
+	// responses, honoring Retry-After, for all requests.
+	Retry RetryOptions
+
+	// BreakerThreshold is the number of consecutive failures after which
+	// requests fail with ErrCircuitOpen for BreakerCooldown, so Grafana
+	// that is down is not flooded. Zero disables the circuit breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
 // NewClient returns a new grafana client.
-func NewClient(host, apiKey string) *Client {
+func NewClient(host string, opts ClientOptions) (*Client, error) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
-		host:   host,
-		apiKey: apiKey,
-		client: http.DefaultClient,
+		host:  host,
+		auth:  opts.Auth,
+		orgID: opts.OrgID,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+		},
+		limiter: newLimiter(opts.RateLimit, opts.RateBurst),
+		breaker: newBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+		retry:   opts.Retry,
+
+		dashboardIDs: make(map[string]int),
+	}, nil
+}
+
+func newTransport(opts ClientOptions) (*http.Transport, error) {
+	proxy := http.ProxyFromEnvironment
+	if opts.ProxyURL != "" {
+		u, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse proxy url")
+		}
+		proxy = http.ProxyURL(u)
 	}
+
+	tlsConfig := &tls.Config{}
+
+	if opts.CAFile != "" {
+		b, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificates found in ca file %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Same as http.DefaultTransport, except for proxy and TLS config.
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}, nil
 }
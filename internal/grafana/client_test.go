@@ -0,0 +1,492 @@
+package grafana_test
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/grafana"
+)
+
+// Tests that client authorizes requests with configured credentials
+// and makes them in the configured organization.
+func TestClient_GetAnnotations_auth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chronologist")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600))
+
+	testCases := []struct {
+		name          string
+		creds         grafana.Credentials
+		orgID         int64
+		authorization string
+		orgHeader     string
+	}{
+		{
+			name: "anonymous",
+		},
+		{
+			name:          "api key",
+			creds:         grafana.Credentials{APIKey: "key"},
+			authorization: "Bearer key",
+		},
+		{
+			name:          "service account token",
+			creds:         grafana.Credentials{ServiceAccountToken: "token"},
+			orgID:         2,
+			authorization: "Bearer token",
+			orgHeader:     "2",
+		},
+		{
+			name:          "basic auth",
+			creds:         grafana.Credentials{Username: "admin", Password: "secret"},
+			orgID:         2,
+			authorization: "Basic YWRtaW46c2VjcmV0",
+			orgHeader:     "2",
+		},
+		{
+			name:          "token file",
+			creds:         grafana.Credentials{TokenFile: tokenFile},
+			orgID:         3,
+			authorization: "Bearer file-token",
+			orgHeader:     "3",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tc.authorization, r.Header.Get("Authorization"))
+				assert.Equal(t, tc.orgHeader, r.Header.Get("X-Grafana-Org-Id"))
+				_, _ = w.Write([]byte(`[]`))
+			}))
+			defer srv.Close()
+
+			auth, err := tc.creds.Authorizer(zap.NewNop())
+			require.NoError(t, err)
+
+			client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{OrgID: tc.orgID, Auth: auth})
+			require.NoError(t, err)
+
+			aa, err := client.GetAnnotations(context.Background(), grafana.GetAnnotationsParams{})
+			assert.NoError(t, err)
+			assert.Empty(t, aa)
+		})
+	}
+}
+
+// Tests that client verifies Grafana with the configured CA bundle, makes
+// requests through the configured proxy, and fails to be created with
+// invalid transport options.
+func TestNewClient_transport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chronologist")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer tlsSrv.Close()
+
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer proxy.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: tlsSrv.Certificate().Raw,
+	}), 0600))
+	garbageFile := filepath.Join(dir, "garbage.pem")
+	require.NoError(t, ioutil.WriteFile(garbageFile, []byte("garbage"), 0600))
+
+	testCases := []struct {
+		name      string
+		host      string
+		opts      grafana.ClientOptions
+		createErr bool
+		getErr    bool
+	}{
+		{
+			name: "ca file",
+			host: tlsSrv.URL,
+			opts: grafana.ClientOptions{CAFile: caFile},
+		},
+		{
+			name:   "unknown authority",
+			host:   tlsSrv.URL,
+			getErr: true,
+		},
+		{
+			name: "proxy",
+			host: "http://grafana.invalid",
+			opts: grafana.ClientOptions{ProxyURL: proxy.URL},
+		},
+		{
+			name:      "missing ca file",
+			opts:      grafana.ClientOptions{CAFile: filepath.Join(dir, "missing.pem")},
+			createErr: true,
+		},
+		{
+			name:      "no certificates in ca file",
+			opts:      grafana.ClientOptions{CAFile: garbageFile},
+			createErr: true,
+		},
+		{
+			name:      "invalid client certificate",
+			opts:      grafana.ClientOptions{CertFile: garbageFile, KeyFile: garbageFile},
+			createErr: true,
+		},
+		{
+			name:      "client certificate without key",
+			opts:      grafana.ClientOptions{CertFile: caFile},
+			createErr: true,
+		},
+		{
+			name:      "invalid proxy url",
+			opts:      grafana.ClientOptions{ProxyURL: "http://[::1"},
+			createErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := grafana.NewClient(tc.host, tc.opts)
+			if tc.createErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			_, err = client.GetAnnotations(context.Background(), grafana.GetAnnotationsParams{})
+			if tc.getErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	assert.Equal(t, "http://grafana.invalid/api/annotations?limit=1000", proxied)
+}
+
+// Tests that only one kind of credentials can be set.
+func TestCredentials_Authorizer_ambiguous(t *testing.T) {
+	_, err := grafana.Credentials{APIKey: "key", ServiceAccountToken: "token"}.Authorizer(zap.NewNop())
+	assert.EqualError(t, err, "only one kind of credentials can be set, got api key, service account token")
+}
+
+// Tests that token file is reloaded when it changes.
+func TestTokenFile_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chronologist")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(path, []byte("foo\n"), 0600))
+
+	f, err := grafana.NewTokenFile(path, zap.NewNop())
+	require.NoError(t, err)
+
+	authorization := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		f.Authorize(req)
+		return req.Header.Get("Authorization")
+	}
+	assert.Equal(t, "Bearer foo", authorization())
+
+	reloaded, err := f.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("barbaz\n"), 0600))
+
+	reloaded, err = f.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "Bearer barbaz", authorization())
+
+	// Empty file, e.g. while the secret is being updated, is ignored.
+	require.NoError(t, ioutil.WriteFile(path, nil, 0600))
+
+	_, err = f.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, "Bearer barbaz", authorization())
+
+	// Removed file, e.g. while the secret is being remounted, is ignored.
+	require.NoError(t, os.Remove(path))
+
+	_, err = f.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, "Bearer barbaz", authorization())
+}
+
+// Tests that token file must exist and have a token to start with.
+func TestNewTokenFile_invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chronologist")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, ioutil.WriteFile(empty, []byte(" \n"), 0600))
+
+	for _, path := range []string{empty, filepath.Join(dir, "missing")} {
+		_, err = grafana.NewTokenFile(path, zap.NewNop())
+		assert.Error(t, err, path)
+
+		_, err = grafana.Credentials{TokenFile: path}.Authorizer(zap.NewNop())
+		assert.Error(t, err, path)
+	}
+}
+
+// Tests that client retries rate limited requests honoring Retry-After.
+func TestClient_GetAnnotations_retry(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id":1}]`))
+	}))
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{
+		RateLimit: 100,
+		RateBurst: 1,
+		Retry: grafana.RetryOptions{
+			MaxRetries: 3,
+			MinWait:    time.Millisecond,
+			MaxWait:    time.Second,
+		},
+	})
+	require.NoError(t, err)
+
+	aa, err := client.GetAnnotations(context.Background(), grafana.GetAnnotationsParams{})
+	assert.NoError(t, err)
+	assert.Equal(t, grafana.Annotations{{ID: 1}}, aa)
+	assert.Equal(t, 2, calls)
+}
+
+// Tests that client stops making requests when Grafana is down.
+func TestClient_DeleteAnnotation_circuitBreaker(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Hour,
+	})
+	require.NoError(t, err)
+
+	assert.Error(t, client.DeleteAnnotation(context.Background(), 1))
+	assert.Error(t, client.DeleteAnnotation(context.Background(), 1))
+	err = client.DeleteAnnotation(context.Background(), 1)
+	assert.Equal(t, grafana.ErrCircuitOpen, errors.Cause(err))
+	wait := chronologist.RetryAfter(err)
+	assert.True(t, wait > 59*time.Minute && wait <= time.Hour, wait)
+	assert.Equal(t, 2, calls)
+}
+
+// Tests that client returns API errors with details from Grafana response.
+func TestClient_DeleteAnnotation_apiError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"message":"Invalid API key"}`))
+	}))
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	err = client.DeleteAnnotation(context.Background(), 123)
+	assert.EqualError(t, err, "grafana api DELETE /api/annotations/123: got response 401 Unauthorized: Invalid API key")
+	assert.True(t, grafana.IsUnauthorized(err))
+	assert.False(t, grafana.IsNotFound(err))
+	assert.False(t, grafana.IsRateLimited(err))
+}
+
+// Tests that client fetches all annotations page by page.
+func TestClient_GetAnnotations_pagination(t *testing.T) {
+	// 2500 annotations, one per second, the newest first.
+	var all grafana.Annotations
+	for i := 2500; i > 0; i-- {
+		all = append(all, grafana.Annotation{ID: i, UNIXMillis: int64(i) * 1000})
+	}
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "a", r.URL.Query().Get("tags"))
+		assert.Equal(t, "1000", r.URL.Query().Get("limit"))
+
+		to := int64(math.MaxInt64)
+		if v := r.URL.Query().Get("to"); v != "" {
+			to, _ = strconv.ParseInt(v, 10, 64)
+		}
+
+		var page grafana.Annotations
+		for _, a := range all {
+			if a.UNIXMillis <= to && len(page) < 1000 {
+				page = append(page, a)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	aa, err := client.GetAnnotations(context.Background(), grafana.GetAnnotationsParams{Tags: []string{"a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, all, aa)
+	assert.Equal(t, 3, calls)
+}
+
+// Tests that client fails rather than returns a part of annotations when
+// more of them than fit in a page share the same time.
+func TestClient_GetAnnotations_paginationSameTime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var page grafana.Annotations
+		for i := 1; i <= 1000; i++ {
+			page = append(page, grafana.Annotation{ID: i, UNIXMillis: 1000})
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	aa, err := client.GetAnnotations(context.Background(), grafana.GetAnnotationsParams{})
+	assert.EqualError(t, err, "more than 1000 annotations share time 1000, cannot fetch all of them")
+	assert.Nil(t, aa)
+
+	// Limited queries do not need the rest.
+	aa, err = client.GetAnnotations(context.Background(), grafana.GetAnnotationsParams{Limit: 1000})
+	assert.NoError(t, err)
+	assert.Len(t, aa, 1000)
+}
+
+// Tests that client detects Grafana version and rejects unsupported ones.
+func TestClient_DetectVersion(t *testing.T) {
+	version := "10.2.3"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/health", r.URL.Path)
+		_ = json.NewEncoder(w).Encode(map[string]string{"database": "ok", "version": version})
+	}))
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	v, err := client.DetectVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, grafana.Version{Major: 10, Minor: 2, Patch: 3}, v)
+	assert.Equal(t, v, client.Version())
+	assert.True(t, v.DeprecatesAPIKeys())
+
+	version = "4.6.3"
+	v, err = client.DetectVersion(context.Background())
+	assert.EqualError(t, err, "grafana 4.6.3 is not supported, the minimum supported version is 5.0.0")
+	assert.Equal(t, grafana.Version{Major: 4, Minor: 6, Patch: 3}, v)
+}
+
+// Tests that client keeps annotations saved in Grafana 6.4 and newer points,
+// sending their end time along with the time.
+func TestClient_SaveAnnotation_timeEnd(t *testing.T) {
+	testCases := []struct {
+		version string
+		timeEnd interface{}
+	}{
+		{version: "6.3.6", timeEnd: nil},
+		{version: "6.4.0", timeEnd: float64(1000)},
+		{version: "10.2.3", timeEnd: float64(1000)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			var bodies []map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/health" {
+					_ = json.NewEncoder(w).Encode(map[string]string{"version": tc.version})
+					return
+				}
+				var body map[string]interface{}
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+				bodies = append(bodies, body)
+			}))
+			defer srv.Close()
+
+			client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+			require.NoError(t, err)
+			_, err = client.DetectVersion(context.Background())
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			require.NoError(t, client.SaveAnnotation(ctx, grafana.Annotation{UNIXMillis: 1000, Text: "foo"}))
+			require.NoError(t, client.SaveAnnotation(ctx, grafana.Annotation{ID: 1, UNIXMillis: 1000, Text: "foo"}))
+
+			require.Len(t, bodies, 2)
+			for _, body := range bodies {
+				assert.Equal(t, float64(1000), body["time"])
+				assert.Equal(t, tc.timeEnd, body["timeEnd"])
+			}
+		})
+	}
+}
+
+// Tests that failed version probes do not open the circuit breaker.
+func TestClient_DetectVersion_circuitBreaker(t *testing.T) {
+	var deletes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deletes++
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Hour,
+	})
+	require.NoError(t, err)
+
+	v, err := client.DetectVersion(context.Background())
+	assert.Error(t, err)
+	assert.True(t, v.IsZero())
+
+	assert.NoError(t, client.DeleteAnnotation(context.Background(), 1))
+	assert.Equal(t, 1, deletes)
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/lru"
+)
+
+const (
+	// dryRunPlanSize is the maximum number of operations DryRun records.
+	dryRunPlanSize = 10000
+
+	// dryRunSeenSize is the maximum number of annotations read from Grafana
+	// DryRun remembers.
+	dryRunSeenSize = 10000
+)
+
+// OperationType is a type of change in Grafana.
+type OperationType string
+
+// Operation types.
+const (
+	OperationCreate OperationType = "create"
+	OperationUpdate OperationType = "update"
+	OperationDelete OperationType = "delete"
+)
+
+// Operation is a change of an annotation that DryRun would make in Grafana.
+type Operation struct {
+	Type     OperationType `json:"type"`
+	Release  string        `json:"release"`
+	Revision string        `json:"revision"`
+
+	// Before is the annotation as it is in Grafana, unless it is to be
+	// created. After is the annotation as it would be, unless it is to
+	// be deleted.
+	Before *Annotation `json:"before,omitempty"`
+	After  *Annotation `json:"after,omitempty"`
+
+	// Differences between release events of the annotation before and
+	// after an update.
+	Differences []string `json:"differences,omitempty"`
+
+	// Time the operation was planned at.
+	Time time.Time `json:"time"`
+
+	// seq orders operations planned at the same time.
+	seq int
+}
+
+// NewDryRun returns a new DryRun on top of the annotator.
+func NewDryRun(grafana Annotator, log *zap.Logger) *DryRun {
+	return &DryRun{
+		grafana: grafana,
+		log:     log,
+		plan:    make(map[int]*Operation),
+		seen:    lru.New(dryRunSeenSize),
+	}
+}
+
+// DryRun is an Annotator that reads annotations from Grafana, but records
+// the changes it is asked to make instead of making them. Recorded changes
+// are applied to annotations it reads, so those who use it see Grafana as
+// if the changes were made, and the same change is not recorded again on
+// every resync.
+//
+// When dryRunPlanSize operations are recorded, the oldest one is forgotten
+// to record a new one, so a long dry run does not grow unbounded. Forgotten
+// operations are recorded again on the next resync, if still needed.
+type DryRun struct {
+	grafana Annotator
+	log     *zap.Logger
+
+	mx sync.Mutex
+	// plan holds operations by ids of annotations. Annotations to be
+	// created get negative ids.
+	plan    map[int]*Operation
+	lastID  int
+	lastSeq int
+	// seen holds recently read annotations from Grafana by their ids.
+	seen *lru.Cache
+}
+
+// SaveAnnotation implements Annotator.
+func (d *DryRun) SaveAnnotation(ctx context.Context, annotation Annotation) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if annotation.ID == 0 {
+		d.lastID--
+		annotation.ID = d.lastID
+		d.record(annotation.ID, &Operation{Type: OperationCreate, After: &annotation})
+		return nil
+	}
+
+	op, ok := d.plan[annotation.ID]
+	switch {
+	case ok && op.Type == OperationDelete:
+		return &APIError{StatusCode: http.StatusNotFound, Message: "Annotation not found", Method: http.MethodPut}
+	case ok && op.Type == OperationCreate:
+		d.record(annotation.ID, &Operation{Type: OperationCreate, After: &annotation})
+		return nil
+	case ok:
+		d.record(annotation.ID, d.update(*op.Before, annotation))
+		return nil
+	}
+
+	d.record(annotation.ID, d.update(d.find(annotation.ID), annotation))
+	return nil
+}
+
+// update returns an update operation of the annotation.
+func (d *DryRun) update(before, after Annotation) *Operation {
+	diffs := after.ToReleaseEvent().Differences(before.ToReleaseEvent())
+	if len(diffs) == 0 && !chronologist.SameTags(before.Tags, after.Tags) {
+		// Tags not describing the release event changed, e.g. extra tags.
+		diffs = append(diffs, fmt.Sprintf("Tags: %v != %v", after.Tags, before.Tags))
+	}
+	return &Operation{
+		Type:        OperationUpdate,
+		Before:      &before,
+		After:       &after,
+		Differences: diffs,
+	}
+}
+
+// DeleteAnnotation implements Annotator.
+func (d *DryRun) DeleteAnnotation(ctx context.Context, id int) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	op, ok := d.plan[id]
+	switch {
+	case ok && op.Type == OperationDelete:
+		return &APIError{StatusCode: http.StatusNotFound, Message: "Annotation not found", Method: http.MethodDelete}
+	case ok && op.Type == OperationCreate:
+		// The annotation was never created, so there is nothing to do.
+		d.log.Sugar().Infof("Dry run: canceled creation of annotation for release %s revision %s", op.Release, op.Revision)
+		delete(d.plan, id)
+		return nil
+	case ok:
+		d.record(id, &Operation{Type: OperationDelete, Before: op.Before})
+		return nil
+	}
+
+	before := d.find(id)
+	d.record(id, &Operation{Type: OperationDelete, Before: &before})
+	return nil
+}
+
+// find returns the annotation read from Grafana by its id.
+func (d *DryRun) find(id int) Annotation {
+	if a, ok := d.seen.Get(strconv.Itoa(id)); ok {
+		return a.(Annotation)
+	}
+	// Callers always get annotations before changing them, so this should
+	// not happen.
+	return Annotation{ID: id}
+}
+
+// record adds the operation to the plan and logs it.
+func (d *DryRun) record(id int, op *Operation) {
+	a := op.After
+	if a == nil {
+		a = op.Before
+	}
+	re := a.ToReleaseEvent()
+	op.Release = re.Name
+	op.Revision = re.Revision
+	op.Time = time.Now()
+	d.lastSeq++
+	op.seq = d.lastSeq
+	if _, ok := d.plan[id]; !ok && len(d.plan) >= dryRunPlanSize {
+		d.forgetOldest()
+	}
+	d.plan[id] = op
+
+	d.log.Info("Dry run: would "+string(op.Type)+" annotation",
+		zap.String("release", op.Release),
+		zap.String("revision", op.Revision),
+		zap.Strings("differences", op.Differences),
+	)
+}
+
+// forgetOldest removes the oldest operation from the plan.
+func (d *DryRun) forgetOldest() {
+	oldest := 0
+	for id, op := range d.plan {
+		if oldest == 0 || op.seq < d.plan[oldest].seq {
+			oldest = id
+		}
+	}
+	delete(d.plan, oldest)
+}
+
+// GetAnnotations implements Annotator. Annotations are returned as if
+// recorded operations were made.
+func (d *DryRun) GetAnnotations(ctx context.Context, in GetAnnotationsParams) (Annotations, error) {
+	aa, err := d.grafana.GetAnnotations(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	var result Annotations
+	for _, a := range aa {
+		d.seen.Add(strconv.Itoa(a.ID), a)
+
+		op, ok := d.plan[a.ID]
+		switch {
+		case !ok:
+			result = append(result, a)
+		case op.Type == OperationUpdate && in.matches(*op.After):
+			result = append(result, *op.After)
+		}
+	}
+	for _, op := range d.plan {
+		if op.Type == OperationCreate && in.matches(*op.After) {
+			result = append(result, *op.After)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].UNIXMillis > result[j].UNIXMillis
+	})
+	if in.Limit > 0 && len(result) > in.Limit {
+		result = result[:in.Limit]
+	}
+	return result, nil
+}
+
+// Operations returns recorded operations in the order they were planned.
+func (d *DryRun) Operations() []Operation {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	ops := make([]Operation, 0, len(d.plan))
+	for _, op := range d.plan {
+		ops = append(ops, *op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].seq < ops[j].seq
+	})
+	return ops
+}
@@ -0,0 +1,91 @@
+package grafana_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/grafana"
+	"github.com/hypnoglow/chronologist/internal/grafana/fake"
+)
+
+// Tests that dry run records changes chronicle makes instead of making them
+// in Grafana, and presents Grafana as if they were made.
+func TestDryRun(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	foo := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+	bar := foo
+	bar.Name = "bar"
+	srv.AddAnnotation(grafana.AnnotationFromEvent(0, bar))
+
+	dr := grafana.NewDryRun(client, zap.NewNop())
+	cr := grafana.NewChronicle(dr, zap.NewNop(), grafana.Options{})
+
+	// Registering twice plans a single creation.
+	require.NoError(t, cr.Register(context.Background(), foo))
+	require.NoError(t, cr.Register(context.Background(), foo))
+
+	bar.Status = "SUPERSEDED"
+	require.NoError(t, cr.Register(context.Background(), bar))
+
+	ops := dr.Operations()
+	require.Len(t, ops, 2)
+	assert.Equal(t, grafana.OperationCreate, ops[0].Type)
+	assert.Equal(t, "foo", ops[0].Release)
+	assert.Equal(t, grafana.OperationUpdate, ops[1].Type)
+	assert.Equal(t, "bar", ops[1].Release)
+	assert.Equal(t, []string{"Status: SUPERSEDED != DEPLOYED"}, ops[1].Differences)
+
+	// Unregistering a release whose creation is planned cancels it.
+	require.NoError(t, cr.Unregister(context.Background(), "foo", "1"))
+	require.NoError(t, cr.Unregister(context.Background(), "bar", "1"))
+
+	ops = dr.Operations()
+	require.Len(t, ops, 1)
+	assert.Equal(t, grafana.OperationDelete, ops[0].Type)
+	assert.Equal(t, "bar", ops[0].Release)
+
+	// Grafana is left intact.
+	aa := srv.Annotations()
+	require.Len(t, aa, 1)
+	assert.Contains(t, aa[0].Tags, "release_status=DEPLOYED")
+}
+
+// Tests that dry run forgets the oldest operations rather than grows
+// unbounded.
+func TestDryRun_bounded(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	dr := grafana.NewDryRun(client, zap.NewNop())
+	for i := 0; i <= 10000; i++ {
+		re := chronologist.ReleaseEvent{Name: "foo", Revision: strconv.Itoa(i)}
+		require.NoError(t, dr.SaveAnnotation(context.Background(), grafana.AnnotationFromEvent(0, re)))
+	}
+
+	ops := dr.Operations()
+	require.Len(t, ops, 10000)
+	assert.Equal(t, "1", ops[0].Revision)
+	assert.Equal(t, "10000", ops[len(ops)-1].Revision)
+}
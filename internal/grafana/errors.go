@@ -0,0 +1,107 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxErrorBodySize limits how much of the response body is read into the
+// error message when Grafana does not return a JSON error.
+const maxErrorBodySize = 512
+
+// APIError is returned when Grafana responds with an unexpected status.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Message is the error message Grafana responded with.
+	Message string
+
+	// Method and Path of the request.
+	Method string
+	Path   string
+}
+
+// Error implements error.
+func (e *APIError) Error() string {
+	s := fmt.Sprintf("grafana api %s %s: got response %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// Temporary reports whether the request may succeed if retried later.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// newAPIError returns an APIError for the response, reading the message
+// from the response body.
+func newAPIError(req *http.Request, resp *http.Response) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     req.Method,
+		Path:       req.URL.Path,
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil {
+		return e
+	}
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(b, &body); err == nil && body.Message != "" {
+		e.Message = body.Message
+	} else {
+		e.Message = strings.TrimSpace(string(b))
+	}
+	return e
+}
+
+// IsNotFound reports whether the error is caused by Grafana responding with
+// 404 Not Found.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized reports whether the error is caused by Grafana rejecting
+// the credentials, responding with 401 Unauthorized or 403 Forbidden.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized) || hasStatus(err, http.StatusForbidden)
+}
+
+// IsRateLimited reports whether the error is caused by Grafana responding
+// with 429 Too Many Requests.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+func hasStatus(err error, status int) bool {
+	e, ok := errors.Cause(err).(*APIError)
+	return ok && e.StatusCode == status
+}
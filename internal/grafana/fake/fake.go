@@ -0,0 +1,538 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hypnoglow/chronologist/internal/grafana"
+)
+
+const (
+	// DefaultVersion is the version of Grafana the fake reports by default.
+	DefaultVersion = "10.0.0"
+
+	// defaultLimit is the number of annotations Grafana returns when the
+	// request does not specify a limit.
+	defaultLimit = 100
+
+	// userID is the id of the user all annotations are created by.
+	userID = 1
+)
+
+// Grafana is an in-memory fake of Grafana HTTP API. It implements the
+// annotations API, the parts of the dashboards and search API the client
+// relies on, and the health endpoint.
+//
+// Dashboards referenced by uid are created on first use, so any routing
+// works out of the box.
+type Grafana struct {
+	mx          sync.Mutex
+	version     string
+	apiKey      string
+	latency     time.Duration
+	faults      []*fault
+	annotations map[int]annotation
+	dashboards  map[string]int
+	lastID      int
+}
+
+// annotation is an annotation as it is stored by the fake.
+type annotation struct {
+	ID           int      `json:"id"`
+	DashboardID  int      `json:"dashboardId"`
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelID      int      `json:"panelId"`
+	UserID       int      `json:"userId"`
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// fault is an error injected into responses.
+type fault struct {
+	method  string
+	path    string
+	status  int
+	message string
+	times   int
+}
+
+func (f *fault) matches(r *http.Request) bool {
+	if f.method != "" && f.method != r.Method {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, f.path)
+}
+
+// New returns a new empty fake Grafana.
+func New() *Grafana {
+	return &Grafana{
+		version:     DefaultVersion,
+		annotations: make(map[int]annotation),
+		dashboards:  make(map[string]int),
+	}
+}
+
+// SetVersion sets the version of Grafana the fake reports. Versions before
+// 10.0 do not report dashboard uids of annotations and cannot filter
+// annotations by dashboard uid, just like real Grafana.
+func (g *Grafana) SetVersion(version string) {
+	g.mx.Lock()
+	g.version = version
+	g.mx.Unlock()
+}
+
+// SetAPIKey makes the fake require the API key as a bearer token.
+// An empty key disables authorization.
+func (g *Grafana) SetAPIKey(key string) {
+	g.mx.Lock()
+	g.apiKey = key
+	g.mx.Unlock()
+}
+
+// SetLatency delays every response by d.
+func (g *Grafana) SetLatency(d time.Duration) {
+	g.mx.Lock()
+	g.latency = d
+	g.mx.Unlock()
+}
+
+// Fail makes the next times requests with the method and the path prefix
+// fail with the status and the message. Empty method or path match any
+// request, and non-positive times makes requests fail until ClearFailures
+// is called.
+func (g *Grafana) Fail(method, path string, status int, message string, times int) {
+	g.mx.Lock()
+	g.faults = append(g.faults, &fault{
+		method:  method,
+		path:    path,
+		status:  status,
+		message: message,
+		times:   times,
+	})
+	g.mx.Unlock()
+}
+
+// ClearFailures removes all failures injected with Fail.
+func (g *Grafana) ClearFailures() {
+	g.mx.Lock()
+	g.faults = nil
+	g.mx.Unlock()
+}
+
+// AddDashboard adds a dashboard with the uid and returns its id.
+// If the dashboard already exists, its id is returned.
+func (g *Grafana) AddDashboard(uid string) int {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	return g.dashboard(uid)
+}
+
+func (g *Grafana) dashboard(uid string) int {
+	if id, ok := g.dashboards[uid]; ok {
+		return id
+	}
+	id := len(g.dashboards) + 1
+	g.dashboards[uid] = id
+	return id
+}
+
+// AddAnnotation stores the annotation as if it was created via API and
+// returns its id.
+func (g *Grafana) AddAnnotation(a grafana.Annotation) int {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	return g.create(a)
+}
+
+func (g *Grafana) create(a grafana.Annotation) int {
+	g.lastID++
+	stored := annotation{
+		ID:           g.lastID,
+		DashboardID:  a.DashboardID,
+		DashboardUID: a.DashboardUID,
+		PanelID:      a.PanelID,
+		UserID:       userID,
+		Time:         a.UNIXMillis,
+		TimeEnd:      a.UNIXMillis,
+		Tags:         append([]string{}, a.Tags...),
+		Text:         a.Text,
+	}
+	if stored.DashboardUID != "" {
+		stored.DashboardID = g.dashboard(stored.DashboardUID)
+	} else if stored.DashboardID != 0 {
+		stored.DashboardUID = g.dashboardUID(stored.DashboardID)
+	}
+	if stored.Time == 0 {
+		stored.Time = time.Now().UnixNano() / int64(time.Millisecond)
+		stored.TimeEnd = stored.Time
+	}
+	g.annotations[stored.ID] = stored
+	return stored.ID
+}
+
+func (g *Grafana) dashboardUID(id int) string {
+	for uid, knownID := range g.dashboards {
+		if knownID == id {
+			return uid
+		}
+	}
+	return ""
+}
+
+// Annotations returns all stored annotations, newest first.
+func (g *Grafana) Annotations() grafana.Annotations {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	var aa grafana.Annotations
+	for _, a := range g.sorted() {
+		aa = append(aa, grafana.Annotation{
+			ID:           a.ID,
+			DashboardID:  a.DashboardID,
+			DashboardUID: a.DashboardUID,
+			PanelID:      a.PanelID,
+			UNIXMillis:   a.Time,
+			Tags:         a.Tags,
+			Text:         a.Text,
+		})
+	}
+	return aa
+}
+
+// sorted returns stored annotations, newest first, as Grafana does.
+func (g *Grafana) sorted() []annotation {
+	aa := make([]annotation, 0, len(g.annotations))
+	for _, a := range g.annotations {
+		aa = append(aa, a)
+	}
+	sort.Slice(aa, func(i, j int) bool {
+		if aa[i].Time != aa[j].Time {
+			return aa[i].Time > aa[j].Time
+		}
+		return aa[i].ID > aa[j].ID
+	})
+	return aa
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Grafana) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mx.Lock()
+	latency := g.latency
+	g.mx.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if f := g.fault(r); f != nil {
+		respond(w, f.status, message(f.message))
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	// Health endpoint does not require authorization.
+	if path == "/api/health" && r.Method == http.MethodGet {
+		respond(w, http.StatusOK, map[string]string{"database": "ok", "version": g.version})
+		return
+	}
+
+	if g.apiKey != "" && r.Header.Get("Authorization") != "Bearer "+g.apiKey {
+		respond(w, http.StatusUnauthorized, message("Unauthorized"))
+		return
+	}
+
+	switch {
+	case path == "/api/frontend/settings" && r.Method == http.MethodGet:
+		var body struct {
+			BuildInfo struct {
+				Version string `json:"version"`
+			} `json:"buildInfo"`
+		}
+		body.BuildInfo.Version = g.version
+		respond(w, http.StatusOK, body)
+	case path == "/api/annotations" && r.Method == http.MethodGet:
+		g.findAnnotations(w, r)
+	case path == "/api/annotations" && r.Method == http.MethodPost:
+		g.createAnnotation(w, r)
+	case strings.HasPrefix(path, "/api/annotations/") && r.Method == http.MethodPut:
+		g.updateAnnotation(w, r, strings.TrimPrefix(path, "/api/annotations/"))
+	case strings.HasPrefix(path, "/api/annotations/") && r.Method == http.MethodDelete:
+		g.deleteAnnotation(w, strings.TrimPrefix(path, "/api/annotations/"))
+	case strings.HasPrefix(path, "/api/dashboards/uid/") && r.Method == http.MethodGet:
+		g.getDashboard(w, strings.TrimPrefix(path, "/api/dashboards/uid/"))
+	case path == "/api/search" && r.Method == http.MethodGet:
+		g.search(w, r)
+	default:
+		respond(w, http.StatusNotFound, message("Not found"))
+	}
+}
+
+// fault returns the injected failure for the request, if any.
+func (g *Grafana) fault(r *http.Request) *fault {
+	for i, f := range g.faults {
+		if !f.matches(r) {
+			continue
+		}
+		if f.times > 0 {
+			f.times--
+			if f.times == 0 {
+				g.faults = append(g.faults[:i], g.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func (g *Grafana) findAnnotations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	intParam := func(name string) (int64, bool) {
+		v := query.Get(name)
+		if v == "" {
+			return 0, true
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+
+	from, okFrom := intParam("from")
+	to, okTo := intParam("to")
+	limit, okLimit := intParam("limit")
+	dashboardID, okDashboard := intParam("dashboardId")
+	panelID, okPanel := intParam("panelId")
+	user, okUser := intParam("userId")
+	if !okFrom || !okTo || !okLimit || !okDashboard || !okPanel || !okUser {
+		respond(w, http.StatusBadRequest, message("bad request data"))
+		return
+	}
+	if limit == 0 {
+		limit = defaultLimit
+	}
+
+	dashboardUID := ""
+	if g.supportsDashboardUID() {
+		dashboardUID = query.Get("dashboardUID")
+	}
+
+	aa := []annotation{}
+	if query.Get("type") == grafana.TypeAlert {
+		// The fake knows nothing about alerts.
+		respond(w, http.StatusOK, aa)
+		return
+	}
+
+	for _, a := range g.sorted() {
+		switch {
+		case from != 0 && a.TimeEnd < from,
+			to != 0 && a.Time > to,
+			dashboardID != 0 && int64(a.DashboardID) != dashboardID,
+			dashboardUID != "" && a.DashboardUID != dashboardUID,
+			panelID != 0 && int64(a.PanelID) != panelID,
+			user != 0 && int64(a.UserID) != user,
+			!hasTags(a.Tags, query["tags"], query.Get("matchAny") == "true"):
+			continue
+		}
+		if !g.supportsDashboardUID() {
+			a.DashboardUID = ""
+		}
+		aa = append(aa, a)
+		if int64(len(aa)) == limit {
+			break
+		}
+	}
+
+	respond(w, http.StatusOK, aa)
+}
+
+// hasTags reports whether tags contain all of the wanted tags or, if any is
+// set, at least one of them.
+func hasTags(tags, wanted []string, any bool) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+
+	set := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		set[tag] = true
+	}
+
+	for _, tag := range wanted {
+		if set[tag] && any {
+			return true
+		}
+		if !set[tag] && !any {
+			return false
+		}
+	}
+	return !any
+}
+
+func (g *Grafana) createAnnotation(w http.ResponseWriter, r *http.Request) {
+	var a grafana.Annotation
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		respond(w, http.StatusBadRequest, message("bad request data"))
+		return
+	}
+	if a.DashboardUID != "" && !g.supportsDashboardUID() {
+		a.DashboardUID = ""
+	}
+
+	id := g.create(a)
+	respond(w, http.StatusOK, map[string]interface{}{"message": "Annotation added", "id": id})
+}
+
+func (g *Grafana) updateAnnotation(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		respond(w, http.StatusBadRequest, message("annotationId is invalid"))
+		return
+	}
+
+	stored, ok := g.annotations[id]
+	if !ok {
+		respond(w, http.StatusNotFound, message("Annotation not found"))
+		return
+	}
+
+	var a grafana.Annotation
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		respond(w, http.StatusBadRequest, message("bad request data"))
+		return
+	}
+
+	// Like Grafana, update replaces time, tags and text, but keeps
+	// the dashboard and the panel of the annotation.
+	if a.UNIXMillis != 0 {
+		stored.Time = a.UNIXMillis
+		stored.TimeEnd = a.UNIXMillis
+	}
+	stored.Tags = append([]string{}, a.Tags...)
+	stored.Text = a.Text
+	g.annotations[id] = stored
+
+	respond(w, http.StatusOK, message("Annotation updated"))
+}
+
+func (g *Grafana) deleteAnnotation(w http.ResponseWriter, rawID string) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		respond(w, http.StatusBadRequest, message("annotationId is invalid"))
+		return
+	}
+
+	if _, ok := g.annotations[id]; !ok {
+		respond(w, http.StatusNotFound, message("Annotation not found"))
+		return
+	}
+	delete(g.annotations, id)
+
+	respond(w, http.StatusOK, message("Annotation deleted"))
+}
+
+func (g *Grafana) getDashboard(w http.ResponseWriter, uid string) {
+	var body struct {
+		Dashboard struct {
+			ID  int    `json:"id"`
+			UID string `json:"uid"`
+		} `json:"dashboard"`
+	}
+	body.Dashboard.ID = g.dashboard(uid)
+	body.Dashboard.UID = uid
+	respond(w, http.StatusOK, body)
+}
+
+func (g *Grafana) search(w http.ResponseWriter, r *http.Request) {
+	type hit struct {
+		ID   int    `json:"id"`
+		UID  string `json:"uid"`
+		Type string `json:"type"`
+	}
+
+	hits := []hit{}
+	for _, rawID := range r.URL.Query()["dashboardIds"] {
+		id, err := strconv.Atoi(rawID)
+		if err != nil {
+			continue
+		}
+		if uid := g.dashboardUID(id); uid != "" {
+			hits = append(hits, hit{ID: id, UID: uid, Type: "dash-db"})
+		}
+	}
+	respond(w, http.StatusOK, hits)
+}
+
+func (g *Grafana) supportsDashboardUID() bool {
+	v, err := grafana.ParseVersion(g.version)
+	if err != nil {
+		return true
+	}
+	return v.AtLeast(grafana.Version{Major: 10})
+}
+
+func message(msg string) interface{} {
+	return map[string]string{"message": msg}
+}
+
+func respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// Server is a fake Grafana served by httptest.Server.
+type Server struct {
+	*Grafana
+
+	// URL of the server, suitable to be passed to grafana.NewClient.
+	URL string
+
+	srv *httptest.Server
+}
+
+// NewServer starts and returns a new fake Grafana server.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	g := New()
+	srv := httptest.NewServer(g)
+	return &Server{
+		Grafana: g,
+		URL:     srv.URL,
+		srv:     srv,
+	}
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
@@ -0,0 +1,157 @@
+package fake_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/grafana"
+	"github.com/hypnoglow/chronologist/internal/grafana/fake"
+)
+
+// Tests that chronicle manages annotations in the fake Grafana through
+// the client from registering a release to unregistering it.
+func TestServer_chronicle(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+	srv.SetAPIKey("secret")
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{Auth: grafana.BearerToken("secret")})
+	require.NoError(t, err)
+
+	cr := grafana.NewChronicle(client, zap.NewNop(), grafana.Options{
+		Routes: grafana.Routes{
+			{Selector: grafana.Selector{Namespace: "kube-*"}, DashboardUID: "kube", PanelID: 2},
+		},
+	})
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "PENDING_INSTALL",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "kube-system",
+	}
+	require.NoError(t, cr.Register(context.Background(), re))
+
+	re.Status = "DEPLOYED"
+	require.NoError(t, cr.Register(context.Background(), re))
+
+	aa := srv.Annotations()
+	require.Len(t, aa, 1)
+	assert.Equal(t, srv.AddDashboard("kube"), aa[0].DashboardID)
+	assert.Equal(t, "kube", aa[0].DashboardUID)
+	assert.Equal(t, 2, aa[0].PanelID)
+	assert.Equal(t, int64(1546441445000), aa[0].UNIXMillis)
+	assert.Contains(t, aa[0].Tags, "release_status=DEPLOYED")
+
+	require.NoError(t, cr.Unregister(context.Background(), "foo", "1"))
+	assert.Empty(t, srv.Annotations())
+}
+
+// Tests that the fake finds annotations by tags, time range and limit.
+func TestServer_findAnnotations(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+
+	base := time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC)
+	for i, tags := range [][]string{{"a"}, {"a", "b"}, {"b"}, {"c"}} {
+		srv.AddAnnotation(grafana.Annotation{
+			UNIXMillis: base.Add(time.Duration(i)*time.Hour).Unix() * 1000,
+			Tags:       tags,
+		})
+	}
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		params grafana.GetAnnotationsParams
+		ids    []int
+	}{
+		{grafana.GetAnnotationsParams{}, []int{4, 3, 2, 1}},
+		{grafana.GetAnnotationsParams{Tags: []string{"a", "b"}}, []int{2}},
+		{grafana.GetAnnotationsParams{Tags: []string{"a", "b"}, MatchAny: true}, []int{3, 2, 1}},
+		{grafana.GetAnnotationsParams{From: base.Add(time.Hour), To: base.Add(2 * time.Hour)}, []int{3, 2}},
+		{grafana.GetAnnotationsParams{Limit: 3}, []int{4, 3, 2}},
+		{grafana.GetAnnotationsParams{Type: grafana.TypeAlert}, nil},
+	}
+
+	for _, tc := range testCases {
+		aa, err := client.GetAnnotations(context.Background(), tc.params)
+		require.NoError(t, err)
+
+		var ids []int
+		for _, a := range aa {
+			ids = append(ids, a.ID)
+		}
+		assert.Equal(t, tc.ids, ids, "params: %+v", tc.params)
+	}
+}
+
+// Tests that the fake of an older Grafana makes the client resolve
+// dashboard ids and uids.
+func TestServer_olderVersion(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+	srv.SetVersion("6.7.4")
+	id := srv.AddDashboard("kube")
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+	require.NoError(t, err)
+
+	_, err = client.DetectVersion(context.Background())
+	require.NoError(t, err)
+
+	err = client.SaveAnnotation(context.Background(), grafana.Annotation{DashboardUID: "kube", Tags: []string{"a"}})
+	require.NoError(t, err)
+
+	aa, err := client.GetAnnotations(context.Background(), grafana.GetAnnotationsParams{DashboardUID: "kube"})
+	require.NoError(t, err)
+	require.Len(t, aa, 1)
+	assert.Equal(t, id, aa[0].DashboardID)
+	assert.Equal(t, "kube", aa[0].DashboardUID)
+}
+
+// Tests that the fake injects errors and latency.
+func TestServer_faults(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+
+	client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{
+		Timeout: 50 * time.Millisecond,
+		Retry: grafana.RetryOptions{
+			MaxRetries: 1,
+			MinWait:    time.Millisecond,
+			MaxWait:    time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+
+	// A single failure is retried.
+	srv.Fail(http.MethodGet, "/api/annotations", http.StatusServiceUnavailable, "down", 1)
+	_, err = client.GetAnnotations(context.Background(), grafana.GetAnnotationsParams{})
+	assert.NoError(t, err)
+
+	srv.Fail(http.MethodDelete, "", http.StatusInternalServerError, "boom", 0)
+	err = client.DeleteAnnotation(context.Background(), 1)
+	require.Error(t, err)
+	apiErr, ok := err.(*grafana.APIError)
+	require.True(t, ok, "unexpected error: %v", err)
+	assert.Equal(t, "boom", apiErr.Message)
+
+	srv.ClearFailures()
+	err = client.DeleteAnnotation(context.Background(), 1)
+	assert.True(t, grafana.IsNotFound(err))
+
+	srv.SetLatency(time.Second)
+	_, err = client.GetAnnotations(context.Background(), grafana.GetAnnotationsParams{})
+	assert.Error(t, err)
+}
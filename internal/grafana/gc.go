@@ -0,0 +1,219 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/problems"
+)
+
+// GCPolicy tells which orphaned annotations the garbage collector deletes.
+type GCPolicy string
+
+const (
+	// GCPolicyPurged deletes annotations only of releases that have no
+	// revisions left, i.e. were purged. Annotations of old revisions helm
+	// pruned because of the history limit are kept.
+	GCPolicyPurged GCPolicy = "purged"
+
+	// GCPolicyRevision deletes annotations of any release revision that
+	// no longer exists.
+	GCPolicyRevision GCPolicy = "revision"
+)
+
+// String implements fmt.Stringer.
+func (p GCPolicy) String() string {
+	return string(p)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *GCPolicy) UnmarshalText(text []byte) error {
+	switch GCPolicy(text) {
+	case GCPolicyPurged, GCPolicyRevision:
+		*p = GCPolicy(text)
+		return nil
+	default:
+		return fmt.Errorf("unknown gc policy: %q", text)
+	}
+}
+
+// CollectorOptions represent garbage collector options.
+type CollectorOptions struct {
+	// Cluster is the name of the cluster Chronologist runs in. When set,
+	// only annotations tagged with it are collected. When empty, all
+	// annotations created by Chronologist are considered to belong to
+	// this cluster.
+	Cluster string
+
+	// Target is the name of the Grafana target to collect annotations of.
+	// When empty, annotations of the primary Grafana are collected.
+	Target string
+
+	// Policy tells which orphaned annotations to delete.
+	// Defaults to GCPolicyPurged.
+	Policy GCPolicy
+
+	// Interval between collections.
+	Interval time.Duration
+
+	// DryRun makes the collector only report orphaned annotations
+	// without deleting them.
+	DryRun bool
+}
+
+// NewCollector returns a new garbage collector of annotations of releases
+// that no longer exist.
+func NewCollector(grafana Annotator, releases chronologist.Lister, log *zap.Logger, opts CollectorOptions) *Collector {
+	if opts.Policy == "" {
+		opts.Policy = GCPolicyPurged
+	}
+
+	return &Collector{
+		grafana:  grafana,
+		releases: releases,
+		log:      log,
+		opts:     opts,
+	}
+}
+
+// Collector deletes orphaned annotations, which accumulate when releases are
+// deleted while Chronologist is down, or while they are too old to be
+// watched.
+type Collector struct {
+	grafana  Annotator
+	releases chronologist.Lister
+	log      *zap.Logger
+	opts     CollectorOptions
+}
+
+// GCReport describes the result of a garbage collection.
+type GCReport struct {
+	// Scanned is the number of annotations owned by this cluster.
+	Scanned int
+
+	// Orphans are annotations of release revisions that no longer exist.
+	Orphans Annotations
+
+	// Deleted is the number of orphans deleted. It is less than the number
+	// of orphans when the policy keeps some of them, in dry run mode, or
+	// when some deletions failed.
+	Deleted int
+
+	// DryRun tells whether the collection was a dry run.
+	DryRun bool
+}
+
+// String returns a human-readable summary of the report.
+func (r GCReport) String() string {
+	s := fmt.Sprintf("scanned %d annotations, found %d orphans, deleted %d", r.Scanned, len(r.Orphans), r.Deleted)
+	if r.DryRun {
+		s += " (dry run)"
+	}
+	return s
+}
+
+// Run collects garbage every interval until stopCh is closed.
+func (c *Collector) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			report, err := c.Collect(context.Background())
+			if err != nil {
+				c.log.Sugar().Errorf("Failed to collect orphaned Grafana annotations: %s", err)
+			}
+			c.log.Sugar().Infof("Collected orphaned Grafana annotations: %s", report)
+		}
+	}
+}
+
+// Collect finds annotations of release revisions that no longer exist, and
+// deletes those the policy allows to delete.
+func (c *Collector) Collect(ctx context.Context) (GCReport, error) {
+	report := GCReport{DryRun: c.opts.DryRun}
+
+	q := GetAnnotationsParams{Type: TypeAnnotation}
+	q.Owned(c.opts.Cluster, c.opts.Target)
+
+	// Annotations are listed before releases: a release always exists
+	// before its annotation, so an annotation created in between is not
+	// mistaken for an orphan.
+	aa, err := c.grafana.GetAnnotations(ctx, q)
+	if err != nil {
+		return report, errors.Wrap(err, "get annotations from grafana")
+	}
+	aa = aa.OfTarget(c.opts.Target)
+	report.Scanned = len(aa)
+
+	events, err := c.releases.ListReleaseEvents(ctx)
+	if err != nil {
+		// Without knowing every release, live annotations could be
+		// deleted, so it is not safe to proceed.
+		return report, errors.Wrap(err, "list releases")
+	}
+
+	releases := make(map[string]bool)
+	revisions := make(map[string]bool)
+	for _, re := range events {
+		releases[re.Name] = true
+		revisions[re.Name+"/"+re.Revision] = true
+	}
+
+	var errs []error
+	for _, a := range aa {
+		re := a.ToReleaseEvent()
+		if re.Name == "" || re.Revision == "" || revisions[re.Name+"/"+re.Revision] {
+			continue
+		}
+		report.Orphans = append(report.Orphans, a)
+
+		log := c.log.With(
+			zap.Int("annotation", a.ID),
+			zap.String("release", re.Name),
+			zap.String("revision", re.Revision),
+		)
+
+		if c.opts.Policy == GCPolicyPurged && releases[re.Name] {
+			log.Debug("Release revision of the annotation no longer exists, but the release does, keep the annotation")
+			continue
+		}
+		if c.opts.DryRun {
+			log.Info("Would delete orphaned annotation")
+			continue
+		}
+
+		log.Info("Deleting orphaned annotation")
+		if err := c.grafana.DeleteAnnotation(ctx, a.ID); err != nil && !IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "delete annotation %d", a.ID))
+			continue
+		}
+		report.Deleted++
+	}
+
+	return report, problems.NewAggregate(errs)
+}
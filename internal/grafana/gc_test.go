@@ -0,0 +1,95 @@
+package grafana_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/grafana"
+	"github.com/hypnoglow/chronologist/internal/grafana/fake"
+)
+
+type releaseLister []chronologist.ReleaseEvent
+
+func (l releaseLister) ListReleaseEvents(ctx context.Context) ([]chronologist.ReleaseEvent, error) {
+	return l, nil
+}
+
+// Tests that collector deletes annotations of this cluster whose release
+// revisions no longer exist, as the policy allows.
+func TestCollector_Collect(t *testing.T) {
+	annotate := func(srv *fake.Server, name, revision, cluster string) int {
+		tags := []string{"heritage=chronologist", "release_name=" + name, "release_revision=" + revision}
+		if cluster != "" {
+			tags = append(tags, "cluster="+cluster)
+		}
+		return srv.AddAnnotation(grafana.Annotation{
+			UNIXMillis: time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC).Unix() * 1000,
+			Tags:       tags,
+		})
+	}
+
+	releases := releaseLister{
+		{Name: "foo", Revision: "2"},
+	}
+
+	testCases := map[string]struct {
+		opts    grafana.CollectorOptions
+		orphans int
+		deleted int
+		kept    []string
+	}{
+		"purged": {
+			opts:    grafana.CollectorOptions{Cluster: "prod"},
+			orphans: 2,
+			deleted: 1,
+			kept:    []string{"foo/1", "foo/2", "baz/1"},
+		},
+		"revision": {
+			opts:    grafana.CollectorOptions{Cluster: "prod", Policy: grafana.GCPolicyRevision},
+			orphans: 2,
+			deleted: 2,
+			kept:    []string{"foo/2", "baz/1"},
+		},
+		"dry run": {
+			opts:    grafana.CollectorOptions{Cluster: "prod", Policy: grafana.GCPolicyRevision, DryRun: true},
+			orphans: 2,
+			deleted: 0,
+			kept:    []string{"foo/1", "foo/2", "bar/1", "baz/1"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			srv := fake.NewServer()
+			defer srv.Close()
+
+			annotate(srv, "foo", "1", "prod")
+			annotate(srv, "foo", "2", "prod")
+			annotate(srv, "bar", "1", "prod")
+			// Belongs to another cluster.
+			annotate(srv, "baz", "1", "dev")
+
+			client, err := grafana.NewClient(srv.URL, grafana.ClientOptions{})
+			require.NoError(t, err)
+
+			report, err := grafana.NewCollector(client, releases, zap.NewNop(), tc.opts).Collect(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, 4-1, report.Scanned)
+			assert.Len(t, report.Orphans, tc.orphans)
+			assert.Equal(t, tc.deleted, report.Deleted)
+
+			var kept []string
+			for _, a := range srv.Annotations() {
+				re := a.ToReleaseEvent()
+				kept = append(kept, re.Name+"/"+re.Revision)
+			}
+			assert.ElementsMatch(t, tc.kept, kept)
+		})
+	}
+}
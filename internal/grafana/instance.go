@@ -0,0 +1,198 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// PrimaryInstance is the name of the primary Grafana among instances.
+const PrimaryInstance = "primary"
+
+// Settings describe the primary Grafana and Grafana targets. Commands fill
+// them from their configuration, so all of them talk to Grafana the same way.
+type Settings struct {
+	// Addr is the address of the primary Grafana that annotates all release
+	// events. It can be empty when Targets are set.
+	Addr        string
+	OrgID       int64
+	Credentials Credentials
+
+	// See ClientOptions for these fields.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	ProxyURL string
+	Timeout  time.Duration
+
+	// Routes direct annotations to dashboards and panels of the primary
+	// Grafana.
+	Routes Routes
+
+	// Targets are additional Grafana instances or organizations.
+	Targets Targets
+
+	// Limits apply to the primary Grafana and to each of the targets.
+	Limits Limits
+
+	// Cluster is the name of the cluster annotations are tagged with.
+	Cluster string
+}
+
+// Limits are rate limiting, retries and circuit breaker options shared by
+// Grafana clients. See ClientOptions for the fields.
+type Limits struct {
+	RateLimit        float64
+	RateBurst        int
+	Retry            RetryOptions
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+func (l Limits) apply(opts ClientOptions) ClientOptions {
+	opts.RateLimit = l.RateLimit
+	opts.RateBurst = l.RateBurst
+	opts.Retry = l.Retry
+	opts.BreakerThreshold = l.BreakerThreshold
+	opts.BreakerCooldown = l.BreakerCooldown
+	return opts
+}
+
+// Instance is the primary Grafana or one of the Grafana targets.
+type Instance struct {
+	Name    string
+	Client  *Client
+	Log     *zap.Logger
+	Options Options
+}
+
+// Runner is implemented by authorizers that need a background loop,
+// e.g. to reload a token file.
+type Runner interface {
+	Run(stopCh <-chan struct{})
+}
+
+// NewInstances creates clients of the primary Grafana and of the Grafana
+// targets, adapting each of them to the version of Grafana. It also returns
+// the authorizers that must be run in background.
+func NewInstances(s Settings, log *zap.Logger) ([]Instance, []Runner, error) {
+	if s.Addr == "" && len(s.Targets) == 0 {
+		return nil, nil, errors.New("either grafana addr or grafana targets must be set")
+	}
+
+	var instances []Instance
+	var runners []Runner
+
+	if s.Addr != "" {
+		auth, err := s.Credentials.Authorizer(log)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "grafana credentials")
+		}
+		if r, ok := auth.(Runner); ok {
+			runners = append(runners, r)
+		}
+
+		client, err := NewClient(s.Addr, s.Limits.apply(ClientOptions{
+			OrgID:    s.OrgID,
+			Auth:     auth,
+			CAFile:   s.CAFile,
+			CertFile: s.CertFile,
+			KeyFile:  s.KeyFile,
+			ProxyURL: s.ProxyURL,
+			Timeout:  s.Timeout,
+		}))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "create grafana client")
+		}
+		if err = detectVersion(client, s.Credentials.APIKey != "", log); err != nil {
+			return nil, nil, err
+		}
+
+		instances = append(instances, Instance{
+			Name:   PrimaryInstance,
+			Client: client,
+			Log:    log,
+			Options: Options{
+				Routes:  s.Routes,
+				Cluster: s.Cluster,
+			},
+		})
+	}
+
+	// Each target manages annotations of the release events it selects
+	// independently of others.
+	for _, t := range s.Targets {
+		tlog := log.Named("grafana").With(zap.String("target", t.Name))
+
+		opts, err := t.ClientOptions(tlog)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "grafana target %s", t.Name)
+		}
+		if r, ok := opts.Auth.(Runner); ok {
+			runners = append(runners, r)
+		}
+
+		client, err := NewClient(t.Addr, s.Limits.apply(opts))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "create grafana client for target %s", t.Name)
+		}
+		if err = detectVersion(client, t.APIKey != "", tlog); err != nil {
+			return nil, nil, errors.Wrapf(err, "grafana target %s", t.Name)
+		}
+
+		instances = append(instances, Instance{
+			Name:   t.Name,
+			Client: client,
+			Log:    tlog,
+			Options: Options{
+				Selector: t.Selector,
+				Routes:   t.Routes,
+				Cluster:  s.Cluster,
+				Target:   t.Name,
+			},
+		})
+	}
+
+	return instances, runners, nil
+}
+
+// detectVersion adapts the client to the version of Grafana. It fails only
+// if the version is not supported: when Grafana is not available, the client
+// stays compatible with all supported versions.
+func detectVersion(client *Client, usesAPIKey bool, log *zap.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	v, err := client.DetectVersion(ctx)
+	if err != nil && !v.IsZero() {
+		return err
+	}
+	if err != nil {
+		log.Sugar().Warnf("Failed to detect Grafana version, falling back to the oldest supported %s: %s", MinVersion, err)
+		return nil
+	}
+
+	log.Sugar().Infof("Detected Grafana %s", v)
+	if usesAPIKey && v.DeprecatesAPIKeys() {
+		log.Warn("Grafana API keys are deprecated since Grafana 9.1, use a service account token instead")
+	}
+	return nil
+}
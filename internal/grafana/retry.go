@@ -0,0 +1,327 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is the cause of errors returned by the client instead of
+// making requests while Grafana is considered down. Such errors tell how long
+// the circuit breaker stays open with RetryAfter() time.Duration method.
+var ErrCircuitOpen = errors.New("grafana is considered down, circuit breaker is open")
+
+// circuitOpenError is returned by the client while the circuit breaker is
+// open.
+type circuitOpenError struct {
+	retryAfter time.Duration
+}
+
+func (e circuitOpenError) Error() string { return ErrCircuitOpen.Error() }
+
+// Cause returns ErrCircuitOpen.
+func (e circuitOpenError) Cause() error { return ErrCircuitOpen }
+
+// RetryAfter returns the time left until the circuit breaker lets a request
+// through to check whether Grafana is back.
+func (e circuitOpenError) RetryAfter() time.Duration { return e.retryAfter }
+
+// do makes the request, waiting for the rate limiter, retrying transient
+// failures and tracking them in the circuit breaker.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	return c.doWith(c.breaker, req)
+}
+
+// doWith is like do, but tracks failures in the breaker b. A nil breaker
+// neither blocks the request nor records its outcome.
+func (c *Client) doWith(b *breaker, req *http.Request) (*http.Response, error) {
+	if ok, wait := b.allow(); !ok {
+		return nil, circuitOpenError{retryAfter: wait}
+	}
+
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			b.release()
+			return nil, err
+		}
+
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				b.release()
+				return nil, errors.Wrap(err, "rewind request body")
+			}
+			req.Body = body
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil && ctx.Err() != nil {
+			b.release()
+			return nil, err
+		}
+
+		wait, retry := c.retryWait(req, resp, err, attempt)
+		if !retry {
+			b.record(isFailure(resp, err))
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			b.release()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryWait returns how long to wait before the next attempt, and whether
+// the request should be retried at all.
+func (c *Client) retryWait(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= c.retry.MaxRetries || req.Context().Err() != nil {
+		return 0, false
+	}
+
+	// Requests that are not idempotent are only retried when Grafana
+	// reports it has not processed them.
+	idempotent := req.Method != http.MethodPost && req.Method != http.MethodPatch
+
+	if err != nil {
+		return c.backoff(attempt), idempotent
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		if wait, ok := retryAfter(resp); ok {
+			return wait, wait <= c.retry.MaxWait
+		}
+		return c.backoff(attempt), true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return c.backoff(attempt), idempotent
+	default:
+		return 0, false
+	}
+}
+
+// backoff returns exponential backoff with full jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	max := c.retry.MinWait << uint(attempt)
+	if max <= 0 || max > c.retry.MaxWait {
+		max = c.retry.MaxWait
+	}
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// retryAfter parses Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		wait := time.Until(t)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// isFailure reports whether the request failed because of Grafana being
+// unavailable, as opposed to e.g. a bad request.
+func isFailure(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// RetryOptions represent options of retrying transient failures.
+type RetryOptions struct {
+	// MaxRetries is the maximum number of retries of a request.
+	// Zero disables retries.
+	MaxRetries int
+
+	// MinWait and MaxWait limit the backoff between retries. Requests are
+	// not retried if Grafana asks to wait longer than MaxWait.
+	MinWait time.Duration
+	MaxWait time.Duration
+}
+
+// limiter is a token bucket rate limiter. A nil limiter does not limit.
+type limiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mx     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available or the context is done.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mx.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// Take the token in advance, so concurrent waiters queue up.
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mx.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		l.mx.Lock()
+		l.tokens++
+		l.mx.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// breaker is a circuit breaker. It opens after the threshold of consecutive
+// failures, and lets a single request through after the cooldown to check
+// whether Grafana is back. A nil breaker is always closed.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mx       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a request can be made. If not, it also returns how
+// long to wait until the breaker lets a request through. While a request
+// checks whether Grafana is back, that is the full cooldown.
+func (b *breaker) allow() (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.failures < b.threshold {
+		return true, 0
+	}
+	if b.probing {
+		return false, b.cooldown
+	}
+	if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+		return false, wait
+	}
+	b.probing = true
+	return true, 0
+}
+
+// record records the outcome of an allowed request.
+func (b *breaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// release releases an allowed request without recording its outcome,
+// e.g. when it has been canceled.
+func (b *breaker) release() {
+	if b == nil {
+		return
+	}
+
+	b.mx.Lock()
+	b.probing = false
+	b.mx.Unlock()
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+)
+
+// Selector selects release events by release namespace, name and labels.
+// A zero Selector selects all release events.
+type Selector struct {
+	// Namespace is a pattern the release namespace must match.
+	// See path.Match for the pattern syntax. Empty pattern matches any
+	// namespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Release is a pattern the release name must match.
+	// See path.Match for the pattern syntax. Empty pattern matches any
+	// release.
+	Release string `json:"release,omitempty"`
+
+	// Labels the release must have.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Matches reports whether the release event matches the selector.
+func (s Selector) Matches(re chronologist.ReleaseEvent) bool {
+	if !matchPattern(s.Namespace, re.Namespace) || !matchPattern(s.Release, re.Name) {
+		return false
+	}
+	for k, v := range s.Labels {
+		if re.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) validate() error {
+	if _, err := path.Match(s.Namespace, ""); err != nil {
+		return errors.Wrap(err, "invalid namespace pattern")
+	}
+	if _, err := path.Match(s.Release, ""); err != nil {
+		return errors.Wrap(err, "invalid release pattern")
+	}
+	return nil
+}
+
+func matchPattern(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+// Route directs annotations of selected release events to a dashboard and,
+// optionally, a panel. A zero Route means an organization-wide annotation.
+type Route struct {
+	Selector
+
+	DashboardUID string `json:"dashboardUID,omitempty"`
+	PanelID      int    `json:"panelId,omitempty"`
+}
+
+// Routes is an ordered list of routes.
+type Routes []Route
+
+// For returns the first route the release event matches, or a zero Route
+// if it matches none.
+func (rr Routes) For(re chronologist.ReleaseEvent) Route {
+	for _, r := range rr {
+		if r.Matches(re) {
+			return r
+		}
+	}
+	return Route{}
+}
+
+// routeOf returns the route of the release event. Dashboard declared in the
+// chart takes precedence over the routes.
+func routeOf(routes Routes, re chronologist.ReleaseEvent) Route {
+	uid := re.Chart.Annotations[ChartAnnotationDashboardUID]
+	if uid == "" {
+		return routes.For(re)
+	}
+
+	route := Route{DashboardUID: uid}
+	if panelID, err := strconv.Atoi(re.Chart.Annotations[ChartAnnotationPanelID]); err == nil {
+		route.PanelID = panelID
+	}
+	return route
+}
+
+// dashboardURLWindow is the time range shown by dashboard URLs around the
+// release event.
+const dashboardURLWindow = time.Hour
+
+// DashboardURL returns the URL of the dashboard Grafana at addr shows the
+// annotation of the release event on, routed by the routes, with the time
+// range around the release event. It returns an empty string if the
+// annotation is organization-wide.
+func DashboardURL(addr string, routes Routes, re chronologist.ReleaseEvent) string {
+	route := routeOf(routes, re)
+	if route.DashboardUID == "" {
+		return ""
+	}
+
+	query := url.Values{}
+	query.Set("from", strconv.FormatInt(unixMillis(re.Time.Add(-dashboardURLWindow)), 10))
+	query.Set("to", strconv.FormatInt(unixMillis(re.Time.Add(dashboardURLWindow)), 10))
+	if route.PanelID != 0 {
+		query.Set("viewPanel", strconv.Itoa(route.PanelID))
+	}
+	return strings.TrimSuffix(addr, "/") + "/d/" + url.PathEscape(route.DashboardUID) + "?" + query.Encode()
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Routes are expected
+// in JSON, e.g. `[{"namespace":"payments-*","dashboardUID":"Kx9dVa2Mk"}]`.
+func (rr *Routes) UnmarshalText(text []byte) error {
+	var routes Routes
+	if err := json.Unmarshal(text, &routes); err != nil {
+		return errors.Wrap(err, "decode routes from json")
+	}
+	if err := routes.validate(); err != nil {
+		return err
+	}
+
+	*rr = routes
+	return nil
+}
+
+func (rr Routes) validate() error {
+	for i, r := range rr {
+		if err := r.Selector.validate(); err != nil {
+			return errors.Wrapf(err, "route #%d", i)
+		}
+		if r.PanelID != 0 && r.DashboardUID == "" {
+			return errors.Errorf("route #%d has panel but no dashboard", i)
+		}
+	}
+	return nil
+}
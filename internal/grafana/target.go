@@ -0,0 +1,123 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Target describes a Grafana instance, or an organization in it, that
+// release events are annotated in.
+type Target struct {
+	// Name identifies the target in logs.
+	Name string `json:"name"`
+
+	Addr string `json:"addr"`
+
+	Credentials
+
+	// OrgID is the organization to annotate release events in. When zero,
+	// the organization of the credentials is used.
+	OrgID int64 `json:"orgId,omitempty"`
+
+	// See ClientOptions for these fields. Timeout is a duration string,
+	// e.g. "30s".
+	CAFile   string `json:"caFile,omitempty"`
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	ProxyURL string `json:"proxyURL,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+
+	// Selector selects release events annotated in the target.
+	Selector Selector `json:"selector,omitempty"`
+
+	// Routes direct annotations to dashboards and panels of the target.
+	Routes Routes `json:"routes,omitempty"`
+}
+
+// ClientOptions returns options of the client for the target. The log is
+// used by the authorizer, see Credentials.
+func (t Target) ClientOptions(log *zap.Logger) (ClientOptions, error) {
+	auth, err := t.Credentials.Authorizer(log)
+	if err != nil {
+		return ClientOptions{}, err
+	}
+
+	var timeout time.Duration
+	if t.Timeout != "" {
+		timeout, err = time.ParseDuration(t.Timeout)
+		if err != nil {
+			return ClientOptions{}, errors.Wrap(err, "parse timeout")
+		}
+	}
+
+	return ClientOptions{
+		OrgID:    t.OrgID,
+		Auth:     auth,
+		CAFile:   t.CAFile,
+		CertFile: t.CertFile,
+		KeyFile:  t.KeyFile,
+		ProxyURL: t.ProxyURL,
+		Timeout:  timeout,
+	}, nil
+}
+
+// Targets is a list of targets.
+type Targets []Target
+
+// UnmarshalText implements encoding.TextUnmarshaler. Targets are expected
+// in JSON, e.g.
+// `[{"name":"payments","addr":"http://grafana","apiKey":"...","orgId":2,"selector":{"namespace":"payments-*"}}]`.
+func (tt *Targets) UnmarshalText(text []byte) error {
+	var targets Targets
+	if err := json.Unmarshal(text, &targets); err != nil {
+		return errors.Wrap(err, "decode targets from json")
+	}
+
+	names := make(map[string]bool)
+	for i, t := range targets {
+		if t.Name == "" || t.Addr == "" {
+			return errors.Errorf("target #%d must have name and addr", i)
+		}
+		if t.Name == PrimaryInstance {
+			return errors.Errorf("target #%d name %s is reserved for the primary Grafana", i, t.Name)
+		}
+		if names[t.Name] {
+			return errors.Errorf("target #%d has duplicate name %s", i, t.Name)
+		}
+		names[t.Name] = true
+
+		if t.Timeout != "" {
+			if _, err := time.ParseDuration(t.Timeout); err != nil {
+				return errors.Wrapf(err, "target %s timeout", t.Name)
+			}
+		}
+		if err := t.Selector.validate(); err != nil {
+			return errors.Wrapf(err, "target %s selector", t.Name)
+		}
+		if err := t.Routes.validate(); err != nil {
+			return errors.Wrapf(err, "target %s", t.Name)
+		}
+	}
+
+	*tt = targets
+	return nil
+}
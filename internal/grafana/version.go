@@ -0,0 +1,194 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Version is a Grafana server version.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// MinVersion is the minimum supported Grafana version. Chronologist relies
+// on dashboard uids, which appeared in Grafana 5.0.
+var MinVersion = Version{Major: 5}
+
+// Versions that changed the behaviour of APIs Chronologist uses.
+var (
+	// versionServiceAccounts deprecated API keys in favour of service
+	// account tokens.
+	versionServiceAccounts = Version{Major: 9, Minor: 1}
+
+	// versionAnnotationTimeEnd stores regions as single annotations with
+	// time and timeEnd, and resets timeEnd of annotations updated without
+	// it, so requests must keep timeEnd of point annotations equal to time.
+	versionAnnotationTimeEnd = Version{Major: 6, Minor: 4}
+
+	// versionAnnotationDashboardUID accepts and returns dashboard uids in
+	// annotations API, so there is no need to resolve dashboard ids.
+	versionAnnotationDashboardUID = Version{Major: 10}
+)
+
+// ParseVersion parses Grafana version, e.g. "7.5.2" or "v10.0.0-beta1".
+func ParseVersion(s string) (Version, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Version{}, errors.Errorf("invalid version %q", s)
+	}
+
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, errors.Errorf("invalid version %q", s)
+		}
+		nums[i] = n
+	}
+
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+// String returns the version in "major.minor.patch" form.
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// IsZero reports whether the version is unknown.
+func (v Version) IsZero() bool {
+	return v == Version{}
+}
+
+// AtLeast reports whether the version is the same or newer than v2.
+func (v Version) AtLeast(v2 Version) bool {
+	if v.Major != v2.Major {
+		return v.Major > v2.Major
+	}
+	if v.Minor != v2.Minor {
+		return v.Minor > v2.Minor
+	}
+	return v.Patch >= v2.Patch
+}
+
+// DeprecatesAPIKeys reports whether Grafana of this version deprecates API
+// keys in favour of service account tokens.
+func (v Version) DeprecatesAPIKeys() bool {
+	return v.AtLeast(versionServiceAccounts)
+}
+
+// DetectVersion detects the version of Grafana and adapts the client to it.
+// If the version is not supported, it returns the version along with an
+// error; if the version cannot be detected, it returns a zero Version.
+//
+// Until the version is detected, the client behaves in the most compatible
+// way.
+//
+// See:
+// - http://docs.grafana.org/v5.0/http_api/other/#health-api
+// - http://docs.grafana.org/v5.0/http_api/other/#frontend-settings-api
+func (c *Client) DetectVersion(ctx context.Context) (Version, error) {
+	raw, err := c.healthVersion(ctx)
+	if err != nil || raw == "" {
+		// Health API does not report version in some Grafana versions and
+		// setups, while frontend settings require authorization.
+		raw, err = c.frontendSettingsVersion(ctx)
+		if err != nil {
+			return Version{}, errors.Wrap(err, "get version from frontend settings")
+		}
+	}
+
+	v, err := ParseVersion(raw)
+	if err != nil {
+		return Version{}, err
+	}
+	if !v.AtLeast(MinVersion) {
+		return v, errors.Errorf("grafana %s is not supported, the minimum supported version is %s", v, MinVersion)
+	}
+
+	c.mx.Lock()
+	c.version = v
+	c.mx.Unlock()
+	return v, nil
+}
+
+// Version returns the detected version of Grafana, or a zero Version if it
+// has not been detected.
+func (c *Client) Version() Version {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.version
+}
+
+func (c *Client) healthVersion(ctx context.Context) (string, error) {
+	var body struct {
+		Version string `json:"version"`
+	}
+	err := c.getJSON(ctx, "/health", &body)
+	return body.Version, err
+}
+
+func (c *Client) frontendSettingsVersion(ctx context.Context) (string, error) {
+	var body struct {
+		BuildInfo struct {
+			Version string `json:"version"`
+		} `json:"buildInfo"`
+	}
+	err := c.getJSON(ctx, "/frontend/settings", &body)
+	return body.BuildInfo.Version, err
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, out interface{}) error {
+	u := fmt.Sprintf("%s%s%s", c.host, basePath, path)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req = c.enrichRequest(ctx, req)
+
+	// Probes fail on some Grafana versions and setups by design, e.g. when
+	// frontend settings require authorization, so they must not open the
+	// circuit breaker.
+	resp, err := c.doWith(nil, req)
+	if err != nil {
+		return errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(req, resp)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrap(err, "decode response body from json")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graphite
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// EventFromReleaseEvent assembles a graphite event from the chronologist
+// release event.
+func EventFromReleaseEvent(cluster string, re chronologist.ReleaseEvent) Event {
+	var tags []string
+	for _, tag := range re.Tags() {
+		tags = append(tags, tag.String())
+	}
+	if cluster != "" {
+		tags = append(tags, "cluster="+cluster)
+	}
+
+	return Event{
+		When: float64(re.Time.Unix()),
+		What: re.Summary(),
+		Tags: tags,
+		Data: re.Summary(),
+	}
+}
+
+// NewChronicle returns a new Graphite chronicle.
+func NewChronicle(graphite EventStore, log *zap.Logger, cluster string) *Chronicle {
+	return &Chronicle{
+		graphite: graphite,
+		log:      log,
+		cluster:  cluster,
+	}
+}
+
+// A Chronicle registers release events in Graphite as events.
+//
+// Graphite events API does not support updating or deleting events, so a new
+// event is created each time the release event changes, and removed release
+// events are left as is.
+type Chronicle struct {
+	graphite EventStore
+	log      *zap.Logger
+	cluster  string
+}
+
+// Register adds the release event to the chronicle, creating a graphite event
+// unless the same event already exists.
+func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	log := zaplog.Grasp(ctx, c.log)
+
+	ev := EventFromReleaseEvent(c.cluster, re)
+
+	existing, err := c.graphite.FindEvents(
+		ctx,
+		[]string{"heritage=chronologist", "release_name=" + re.Name, "release_revision=" + re.Revision},
+		re.Time.Add(-time.Second),
+		re.Time.Add(time.Second),
+	)
+	if err != nil {
+		return errors.Wrap(err, "find events in graphite")
+	}
+
+	for _, e := range existing {
+		if chronologist.SameTags(e.Tags, ev.Tags) {
+			log.Debug("Graphite event correctly reflects the release event, sync is not required")
+			return nil
+		}
+	}
+
+	log.Debug("Creating Graphite event for the release event")
+	err = c.graphite.CreateEvent(ctx, ev)
+	return errors.Wrap(err, "create event in graphite")
+}
+
+// Unregister does nothing but logging, because graphite events cannot be
+// deleted using the API.
+func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
+	zaplog.Grasp(ctx, c.log).Debug("Graphite does not support deleting events, skip")
+	return nil
+}
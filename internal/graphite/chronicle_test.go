@@ -0,0 +1,161 @@
+package graphite_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/graphite"
+)
+
+// fakeGraphite implements graphite events API in memory.
+type fakeGraphite struct {
+	mx     sync.Mutex
+	events graphite.Events
+}
+
+func (f *fakeGraphite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "graphite" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/events/":
+		var ev graphite.Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ev.ID = len(f.events) + 1
+		f.events = append(f.events, ev)
+	case r.Method == http.MethodGet && r.URL.Path == "/events/get_data":
+		from, _ := strconv.ParseFloat(r.URL.Query().Get("from"), 64)
+		until, _ := strconv.ParseFloat(r.URL.Query().Get("until"), 64)
+		wanted := strings.Fields(r.URL.Query().Get("tags"))
+
+		found := graphite.Events{}
+		for _, ev := range f.events {
+			if ev.When >= from && ev.When <= until && hasTags(ev.Tags, wanted) {
+				found = append(found, ev)
+			}
+		}
+		json.NewEncoder(w).Encode(found)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeGraphite) Events() graphite.Events {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append(graphite.Events(nil), f.events...)
+}
+
+func hasTags(tags, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Tests that chronicle creates a graphite event for the release event only
+// once, and creates a new one when the release event changes.
+func TestChronicle_Register(t *testing.T) {
+	g := &fakeGraphite{}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	client := graphite.NewClient(srv.URL, "graphite", "secret")
+	cr := graphite.NewChronicle(client, zap.NewNop(), "dev")
+	ctx := context.Background()
+
+	require.NoError(t, cr.Register(ctx, re))
+	require.NoError(t, cr.Register(ctx, re))
+
+	events := g.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, graphite.Event{
+		ID:   1,
+		When: 1546441445,
+		What: "Rollout release foo",
+		Tags: []string{
+			"event=release",
+			"heritage=chronologist",
+			"release_type=rollout",
+			"release_status=DEPLOYED",
+			"release_name=foo",
+			"release_revision=1",
+			"release_namespace=default",
+			"cluster=dev",
+		},
+		Data: "Rollout release foo",
+	}, events[0])
+
+	// Changed status is a new event.
+	re.Status = "SUPERSEDED"
+	require.NoError(t, cr.Register(ctx, re))
+	require.NoError(t, cr.Register(ctx, re))
+	assert.Len(t, g.Events(), 2)
+
+	// The same release event in another cluster is a new event.
+	require.NoError(t, graphite.NewChronicle(client, zap.NewNop(), "prod").Register(ctx, re))
+	assert.Len(t, g.Events(), 3)
+
+	// Events cannot be deleted.
+	require.NoError(t, cr.Unregister(ctx, "foo", "1"))
+	assert.Len(t, g.Events(), 3)
+}
+
+// Tests that chronicle does not create an event when existing events cannot
+// be looked up.
+func TestChronicle_Register_findError(t *testing.T) {
+	var creates int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			creates++
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	cr := graphite.NewChronicle(graphite.NewClient(srv.URL, "", ""), zap.NewNop(), "")
+	err := cr.Register(context.Background(), chronologist.ReleaseEvent{Name: "foo", Revision: "1"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "502 Bad Gateway")
+	}
+	assert.Equal(t, 0, creates)
+}
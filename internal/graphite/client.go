@@ -0,0 +1,145 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Event represents graphite event.
+type Event struct {
+	ID   int      `json:"id,omitempty"`
+	When float64  `json:"when"`
+	What string   `json:"what"`
+	Tags []string `json:"tags"`
+	Data string   `json:"data"`
+}
+
+// Events is a set of graphite events.
+type Events []Event
+
+// EventStore can manage events.
+type EventStore interface {
+	// CreateEvent creates the event.
+	CreateEvent(ctx context.Context, event Event) error
+
+	// FindEvents returns events having all of the tags within the time range.
+	FindEvents(ctx context.Context, tags []string, from, until time.Time) (Events, error)
+}
+
+// Client is a graphite HTTP API client.
+//
+// Client implements EventStore interface using graphite events API.
+type Client struct {
+	host     string
+	username string
+	password string
+
+	client *http.Client
+}
+
+// CreateEvent creates the event in graphite.
+//
+// See: https://graphite.readthedocs.io/en/latest/events.html#adding-events
+func (c *Client) CreateEvent(ctx context.Context, event Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "encode request to json")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.host+"/events/", bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req = c.enrichRequest(ctx, req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("got response %s", resp.Status)
+	}
+
+	return nil
+}
+
+// FindEvents fetches events from graphite.
+//
+// See: https://graphite.readthedocs.io/en/latest/events.html#querying-events
+func (c *Client) FindEvents(ctx context.Context, tags []string, from, until time.Time) (Events, error) {
+	query := url.Values{}
+	query.Set("tags", strings.Join(tags, " "))
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+
+	u := fmt.Sprintf("%s/events/get_data?%s", c.host, query.Encode())
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	req = c.enrichRequest(ctx, req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("got response %s", resp.Status)
+	}
+
+	var ee Events
+	if err = json.NewDecoder(resp.Body).Decode(&ee); err != nil {
+		return nil, errors.Wrap(err, "decode response body from json")
+	}
+
+	return ee, nil
+}
+
+func (c *Client) enrichRequest(ctx context.Context, req *http.Request) *http.Request {
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return req
+}
+
+// NewClient returns a new graphite client. Username and password are
+// optional and enable basic authentication when set.
+func NewClient(host, username, password string) *Client {
+	return &Client{
+		host:     host,
+		username: username,
+		password: password,
+		client:   http.DefaultClient,
+	}
+}
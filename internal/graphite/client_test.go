@@ -0,0 +1,88 @@
+package graphite_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hypnoglow/chronologist/internal/graphite"
+)
+
+func TestClient_CreateEvent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/events/", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		_, _, ok := r.BasicAuth()
+		assert.False(t, ok)
+
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		actual, err := json.Marshal(body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"when":1546441445,"what":"Rollout release foo","tags":["release_name=foo"],"data":"details"}`, string(actual))
+	}))
+	defer srv.Close()
+
+	err := graphite.NewClient(srv.URL, "", "").CreateEvent(context.Background(), graphite.Event{
+		When: 1546441445,
+		What: "Rollout release foo",
+		Tags: []string{"release_name=foo"},
+		Data: "details",
+	})
+	assert.NoError(t, err)
+}
+
+func TestClient_FindEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/events/get_data", r.URL.Path)
+		assert.Equal(t, "heritage=chronologist release_name=foo", r.URL.Query().Get("tags"))
+		assert.Equal(t, "1546441444", r.URL.Query().Get("from"))
+		assert.Equal(t, "1546441446", r.URL.Query().Get("until"))
+
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "graphite", user)
+		assert.Equal(t, "secret", pass)
+
+		w.Write([]byte(`[{"id":7,"when":1546441445.0,"what":"Rollout release foo","tags":["heritage=chronologist","release_name=foo"],"data":""}]`))
+	}))
+	defer srv.Close()
+
+	when := time.Unix(1546441445, 0)
+	ee, err := graphite.NewClient(srv.URL, "graphite", "secret").FindEvents(
+		context.Background(),
+		[]string{"heritage=chronologist", "release_name=foo"},
+		when.Add(-time.Second),
+		when.Add(time.Second),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, graphite.Events{{
+		ID:   7,
+		When: 1546441445,
+		What: "Rollout release foo",
+		Tags: []string{"heritage=chronologist", "release_name=foo"},
+	}}, ee)
+}
+
+func TestClient_errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	client := graphite.NewClient(srv.URL, "graphite", "wrong")
+
+	err := client.CreateEvent(context.Background(), graphite.Event{})
+	assert.EqualError(t, err, "got response 401 Unauthorized")
+
+	_, err = client.FindEvents(context.Background(), nil, time.Now(), time.Now())
+	assert.EqualError(t, err, "got response 401 Unauthorized")
+}
@@ -44,6 +44,8 @@ func EventFromRelease(rel *release.Release) (chronologist.ReleaseEvent, error) {
 		rt = chronologist.ReleaseTypeRollback
 	}
 
+	md := rel.GetChart().GetMetadata()
+
 	return chronologist.ReleaseEvent{
 		Time:      t,
 		Type:      rt,
@@ -51,6 +53,12 @@ func EventFromRelease(rel *release.Release) (chronologist.ReleaseEvent, error) {
 		Name:      rel.Name,
 		Revision:  strconv.Itoa(int(rel.Version)),
 		Namespace: rel.Namespace,
+		Chart: chronologist.Chart{
+			Name:        md.GetName(),
+			Version:     md.GetVersion(),
+			AppVersion:  md.GetAppVersion(),
+			Annotations: md.GetAnnotations(),
+		},
 	}, nil
 }
 
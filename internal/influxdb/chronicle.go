@@ -0,0 +1,100 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// Measurement is the name of the measurement release events are written to.
+const Measurement = "releases"
+
+// PointFromEvent assembles an InfluxDB point from the chronologist release
+// event.
+//
+// Release status is stored as a field and not as a tag, so the point for the
+// release revision is replaced when its status changes.
+func PointFromEvent(cluster string, re chronologist.ReleaseEvent) Point {
+	tags := make(map[string]string)
+	for _, tag := range re.Tags() {
+		if tag.Key == "release_status" {
+			continue
+		}
+		tags[tag.Key] = tag.Value
+	}
+	tags["cluster"] = cluster
+
+	return Point{
+		Measurement: Measurement,
+		Tags:        tags,
+		Fields: map[string]interface{}{
+			"text":   re.Summary(),
+			"status": re.Status,
+		},
+		Time: re.Time,
+	}
+}
+
+// NewChronicle returns a new InfluxDB chronicle.
+func NewChronicle(influx Writer, log *zap.Logger, cluster string) *Chronicle {
+	return &Chronicle{
+		influx:  influx,
+		log:     log,
+		cluster: cluster,
+	}
+}
+
+// A Chronicle registers release events in InfluxDB as points.
+type Chronicle struct {
+	influx  Writer
+	log     *zap.Logger
+	cluster string
+}
+
+// Register adds the release event to the chronicle, writing a corresponding
+// point to InfluxDB. Writing is idempotent, so resyncs do not produce
+// duplicate points.
+func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	zaplog.Grasp(ctx, c.log).Debug("Writing InfluxDB point for the release event")
+
+	err := c.influx.WritePoints(ctx, []Point{PointFromEvent(c.cluster, re)})
+	return errors.Wrap(err, "write point to influxdb")
+}
+
+// Unregister removes the release event from the chronicle, deleting
+// corresponding points from InfluxDB.
+func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
+	zaplog.Grasp(ctx, c.log).Debug("Deleting InfluxDB points related to the release event")
+
+	tags := map[string]string{
+		"heritage":         "chronologist",
+		"release_name":     name,
+		"release_revision": revision,
+	}
+	if c.cluster != "" {
+		tags["cluster"] = c.cluster
+	}
+
+	err := c.influx.DeletePoints(ctx, Measurement, tags)
+	return errors.Wrap(err, "delete points from influxdb")
+}
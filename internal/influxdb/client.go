@@ -0,0 +1,176 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Writer can write and delete points.
+type Writer interface {
+	// WritePoints writes points. Writing a point with the same measurement,
+	// tag set and timestamp replaces its fields.
+	WritePoints(ctx context.Context, points []Point) error
+
+	// DeletePoints deletes all points of the measurement matching the tags.
+	DeletePoints(ctx context.Context, measurement string, tags map[string]string) error
+}
+
+// ClientOptions represent InfluxDB client options.
+//
+// For InfluxDB 1.x, set Database and optionally Username and Password.
+// For InfluxDB 2.x, set Org, Bucket and Token.
+type ClientOptions struct {
+	Database string
+	Username string
+	Password string
+
+	Org    string
+	Bucket string
+	Token  string
+}
+
+// Client is an InfluxDB HTTP API client.
+//
+// Client implements Writer interface.
+type Client struct {
+	host string
+	opts ClientOptions
+
+	client *http.Client
+}
+
+// WritePoints writes points to InfluxDB.
+//
+// See:
+// - https://docs.influxdata.com/influxdb/v1.8/tools/api/#write-http-endpoint
+// - https://docs.influxdata.com/influxdb/v2.0/api/#operation/PostWrite
+func (c *Client) WritePoints(ctx context.Context, points []Point) error {
+	var buf bytes.Buffer
+	for _, p := range points {
+		buf.WriteString(p.Line())
+		buf.WriteString("\n")
+	}
+
+	query := url.Values{}
+	query.Set("precision", "s")
+
+	path := "/write"
+	if c.v2() {
+		path = "/api/v2/write"
+		query.Set("org", c.opts.Org)
+		query.Set("bucket", c.opts.Bucket)
+	} else {
+		query.Set("db", c.opts.Database)
+	}
+
+	return c.do(ctx, http.MethodPost, path, query, "text/plain; charset=utf-8", &buf)
+}
+
+// DeletePoints deletes points from InfluxDB.
+//
+// See:
+// - https://docs.influxdata.com/influxdb/v1.8/query_language/manage-database/#delete-series-with-delete
+// - https://docs.influxdata.com/influxdb/v2.0/api/#operation/PostDelete
+func (c *Client) DeletePoints(ctx context.Context, measurement string, tags map[string]string) error {
+	query := url.Values{}
+
+	if c.v2() {
+		predicate := []string{fmt.Sprintf("_measurement=%q", measurement)}
+		for _, k := range sortedKeys(tags) {
+			predicate = append(predicate, fmt.Sprintf("%s=%q", k, tags[k]))
+		}
+
+		b, err := json.Marshal(map[string]string{
+			"start":     time.Unix(0, 0).UTC().Format(time.RFC3339),
+			"stop":      time.Now().UTC().Add(time.Hour).Format(time.RFC3339),
+			"predicate": strings.Join(predicate, " AND "),
+		})
+		if err != nil {
+			return errors.Wrap(err, "encode request to json")
+		}
+
+		query.Set("org", c.opts.Org)
+		query.Set("bucket", c.opts.Bucket)
+		return c.do(ctx, http.MethodPost, "/api/v2/delete", query, "application/json", bytes.NewReader(b))
+	}
+
+	var where []string
+	for _, k := range sortedKeys(tags) {
+		where = append(where, fmt.Sprintf(`"%s"='%s'`, k, strings.Replace(tags[k], "'", `\'`, -1)))
+	}
+	q := fmt.Sprintf(`DELETE FROM "%s"`, measurement)
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query.Set("db", c.opts.Database)
+	query.Set("q", q)
+	return c.do(ctx, http.MethodPost, "/query", query, "application/x-www-form-urlencoded", nil)
+}
+
+func (c *Client) v2() bool {
+	return c.opts.Bucket != ""
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, contentType string, body io.Reader) error {
+	u := fmt.Sprintf("%s%s?%s", c.host, path, query.Encode())
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+
+	switch {
+	case c.opts.Token != "":
+		req.Header.Set("Authorization", "Token "+c.opts.Token)
+	case c.opts.Username != "":
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return errors.Errorf("got response %s", resp.Status)
+	}
+
+	return nil
+}
+
+// NewClient returns a new InfluxDB client.
+func NewClient(host string, opts ClientOptions) *Client {
+	return &Client{
+		host:   host,
+		opts:   opts,
+		client: http.DefaultClient,
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point represents an InfluxDB point.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// Line encodes the point using InfluxDB line protocol with second precision.
+// Tags with empty values are omitted, because line protocol does not allow
+// them.
+//
+// See: https://docs.influxdata.com/influxdb/v1.8/write_protocols/line_protocol_reference/
+func (p Point) Line() string {
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+
+	for _, k := range sortedKeys(p.Tags) {
+		if p.Tags[k] == "" {
+			continue
+		}
+		b.WriteString(",")
+		b.WriteString(tagEscaper.Replace(k))
+		b.WriteString("=")
+		b.WriteString(tagEscaper.Replace(p.Tags[k]))
+	}
+
+	fields := make([]string, 0, len(p.Fields))
+	for k := range p.Fields {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+
+	for i, k := range fields {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(tagEscaper.Replace(k))
+		b.WriteString("=")
+		b.WriteString(fieldValue(p.Fields[k]))
+	}
+
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(p.Time.Unix(), 10))
+	return b.String()
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+func fieldValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`
+	case int:
+		return strconv.Itoa(v) + "i"
+	case int64:
+		return strconv.FormatInt(v, 10) + "i"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return `"` + stringEscaper.Replace(fmt.Sprint(v)) + `"`
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,41 @@
+package influxdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/influxdb"
+)
+
+func TestPointFromEvent(t *testing.T) {
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	line := influxdb.PointFromEvent("", re).Line()
+
+	assert.Equal(t,
+		`releases,event=release,heritage=chronologist,release_name=foo,release_namespace=default,release_revision=1,release_type=rollout `+
+			`status="DEPLOYED",text="Rollout release foo" 1546441445`,
+		line,
+	)
+}
+
+func TestPoint_Line_escaping(t *testing.T) {
+	p := influxdb.Point{
+		Measurement: "my releases",
+		Tags:        map[string]string{"a b": "c,d=e"},
+		Fields:      map[string]interface{}{"text": `say "hi"`, "n": 3, "ok": true},
+		Time:        time.Unix(10, 0),
+	}
+
+	assert.Equal(t, `my\ releases,a\ b=c\,d\=e n=3i,ok=true,text="say \"hi\"" 10`, p.Line())
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/lru"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+const (
+	actionRegister   = "register"
+	actionUnregister = "unregister"
+)
+
+// pushedSize is the number of release revisions the chronicle remembers
+// the pushed release events of.
+const pushedSize = 10000
+
+// Options represent Loki chronicle options.
+type Options struct {
+	// Cluster is the name of the cluster, used as a stream label.
+	Cluster string
+
+	// BatchSize is the number of log lines that triggers a push.
+	BatchSize int
+
+	// BatchWait is the maximum amount of time a log line waits in the
+	// batch before it is pushed.
+	BatchWait time.Duration
+}
+
+// NewChronicle returns a new Loki chronicle.
+func NewChronicle(loki Pusher, log *zap.Logger, opts Options) *Chronicle {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BatchWait <= 0 {
+		opts.BatchWait = time.Second * 5
+	}
+
+	return &Chronicle{
+		loki:     loki,
+		log:      log,
+		opts:     opts,
+		pushed:   lru.New(pushedSize),
+		flushReq: make(chan struct{}, 1),
+	}
+}
+
+// A Chronicle registers release events in Loki as log lines.
+//
+// Log lines are batched and pushed either when the batch is full or when
+// BatchWait elapses, whatever happens first. Run must be started to push
+// the batches. Log lines are timestamped when they are pushed, as Loki
+// rejects entries older than the last entry of the stream; the time of the
+// release event is a field of the log line.
+type Chronicle struct {
+	loki Pusher
+	log  *zap.Logger
+	opts Options
+
+	mx      sync.Mutex
+	pending []line
+	// pushed holds the last release event pushed for each release revision,
+	// so resyncs do not produce duplicate log lines. It is not persisted, so
+	// release events are pushed again after restart.
+	pushed *lru.Cache
+
+	flushReq chan struct{}
+}
+
+type line struct {
+	labels map[string]string
+	entry  Entry
+}
+
+type record struct {
+	Event     string `json:"event"`
+	Action    string `json:"action"`
+	Cluster   string `json:"cluster,omitempty"`
+	Type      string `json:"release_type,omitempty"`
+	Status    string `json:"release_status,omitempty"`
+	Name      string `json:"release_name"`
+	Revision  string `json:"release_revision"`
+	Namespace string `json:"release_namespace,omitempty"`
+	Time      string `json:"release_time,omitempty"`
+}
+
+// Register adds the release event to the chronicle, queueing a log line for
+// it unless the same release event has already been pushed.
+func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	log := zaplog.Grasp(ctx, c.log)
+
+	key := re.Name + "/" + re.Revision
+
+	prev, ok := c.pushed.Get(key)
+	if ok && len(re.Differences(prev.(chronologist.ReleaseEvent))) == 0 {
+		log.Debug("Release event has already been pushed to Loki, skip")
+		return nil
+	}
+
+	rec := record{
+		Event:     "release",
+		Action:    actionRegister,
+		Cluster:   c.opts.Cluster,
+		Type:      re.Type.String(),
+		Status:    re.Status,
+		Name:      re.Name,
+		Revision:  re.Revision,
+		Namespace: re.Namespace,
+		Time:      re.Time.UTC().Format(time.RFC3339),
+	}
+
+	if err := c.enqueue(rec); err != nil {
+		return err
+	}
+
+	c.pushed.Add(key, re)
+
+	log.Debug("Queued release event to be pushed to Loki")
+	return nil
+}
+
+// Unregister removes the release event from the chronicle. Loki is an
+// append-only store, so this queues a log line recording the removal.
+func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
+	var namespace string
+	if prev, ok := c.pushed.Remove(name + "/" + revision); ok {
+		namespace = prev.(chronologist.ReleaseEvent).Namespace
+	}
+
+	rec := record{
+		Event:     "release",
+		Action:    actionUnregister,
+		Cluster:   c.opts.Cluster,
+		Name:      name,
+		Revision:  revision,
+		Namespace: namespace,
+	}
+
+	zaplog.Grasp(ctx, c.log).Debug("Queued release removal to be pushed to Loki")
+	return c.enqueue(rec)
+}
+
+func (c *Chronicle) enqueue(rec record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "encode log line to json")
+	}
+
+	labels := map[string]string{"job": "chronologist"}
+	// Loki does not accept labels with empty values.
+	for k, v := range map[string]string{
+		"cluster":   rec.Cluster,
+		"namespace": rec.Namespace,
+		"release":   rec.Name,
+	} {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+
+	l := line{
+		labels: labels,
+		entry:  Entry{Line: string(b)},
+	}
+
+	c.mx.Lock()
+	c.pending = append(c.pending, l)
+	full := len(c.pending) >= c.opts.BatchSize
+	c.mx.Unlock()
+
+	if full {
+		select {
+		case c.flushReq <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run pushes batches of log lines until stopCh is closed. Pending log lines
+// are pushed one last time before Run returns.
+func (c *Chronicle) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(c.opts.BatchWait)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			if err := c.Flush(context.Background()); err != nil {
+				c.log.Sugar().Errorf("Failed to push last batch to Loki: %s", err)
+			}
+			return
+		case <-ticker.C:
+		case <-c.flushReq:
+		}
+
+		if err := c.Flush(context.Background()); err != nil {
+			c.log.Sugar().Errorf("Failed to push batch to Loki: %s", err)
+		}
+	}
+}
+
+// Flush pushes all pending log lines to Loki. When Loki is unavailable or
+// rate limits, log lines are kept to be pushed on the next attempt. When
+// Loki rejects them, e.g. as out of order, they are dropped, as pushing
+// them again would fail forever.
+func (c *Chronicle) Flush(ctx context.Context) error {
+	c.mx.Lock()
+	batch := c.pending
+	c.pending = nil
+	c.mx.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// Lines are timestamped in the order they were queued, so entries of
+	// each stream are in order even if they were queued at the same time.
+	now := time.Now()
+	for i := range batch {
+		batch[i].entry.Time = now.Add(time.Duration(i))
+	}
+
+	if err := c.loki.Push(ctx, streamsFromLines(batch)); err != nil {
+		if !chronologist.IsRetryable(err) {
+			return errors.Wrapf(err, "push to loki, dropped %d log lines", len(batch))
+		}
+
+		c.mx.Lock()
+		c.pending = append(batch, c.pending...)
+		// Do not let the buffer grow unbounded while Loki is unavailable.
+		if max := c.opts.BatchSize * 10; len(c.pending) > max {
+			c.log.Sugar().Warnf("Dropping %d log lines that could not be pushed to Loki", len(c.pending)-max)
+			c.pending = c.pending[len(c.pending)-max:]
+		}
+		c.mx.Unlock()
+		return errors.Wrap(err, "push to loki")
+	}
+
+	return nil
+}
+
+// streamsFromLines groups log lines into streams by their labels, keeping
+// entries within each stream in chronological order.
+func streamsFromLines(lines []line) []Stream {
+	var streams []Stream
+	index := make(map[string]int)
+
+	for _, l := range lines {
+		key := labelsKey(l.labels)
+		i, ok := index[key]
+		if !ok {
+			i = len(streams)
+			index[key] = i
+			streams = append(streams, Stream{Labels: l.labels})
+		}
+		streams[i].Entries = append(streams[i].Entries, l.entry)
+	}
+
+	for _, s := range streams {
+		entries := s.Entries
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Time.Before(entries[j].Time)
+		})
+	}
+
+	return streams
+}
+
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k, v := range labels {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,124 @@
+package loki_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/loki"
+)
+
+// Tests that chronicle pushes release events to the Loki push endpoint
+// and does not push the same release event twice.
+func TestChronicle_Register(t *testing.T) {
+	var pushes []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/loki/api/v1/push", r.URL.Path)
+		assert.Equal(t, "tenant", r.Header.Get("X-Scope-OrgID"))
+
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		pushes = append(pushes, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	cr := loki.NewChronicle(loki.NewClient(srv.URL, "tenant"), zap.NewNop(), loki.Options{Cluster: "dev"})
+
+	assert.NoError(t, cr.Register(context.Background(), re))
+	assert.NoError(t, cr.Register(context.Background(), re))
+
+	before := time.Now()
+	assert.NoError(t, cr.Flush(context.Background()))
+
+	if !assert.Len(t, pushes, 1) {
+		return
+	}
+
+	// Log lines are timestamped when pushed rather than with the time
+	// of the release event, which is a field of the log line instead.
+	values := pushes[0]["streams"].([]interface{})[0].(map[string]interface{})["values"].([]interface{})
+	ts, err := strconv.ParseInt(values[0].([]interface{})[0].(string), 10, 64)
+	assert.NoError(t, err)
+	assert.False(t, time.Unix(0, ts).Before(before))
+	values[0].([]interface{})[0] = "<push time>"
+
+	expected := `{"streams":[{"stream":{"cluster":"dev","job":"chronologist","namespace":"default","release":"foo"},` +
+		`"values":[["<push time>","{\"event\":\"release\",\"action\":\"register\",\"cluster\":\"dev\",` +
+		`\"release_type\":\"rollout\",\"release_status\":\"DEPLOYED\",\"release_name\":\"foo\",` +
+		`\"release_revision\":\"1\",\"release_namespace\":\"default\",` +
+		`\"release_time\":\"2019-01-02T15:04:05Z\"}"]]}]}`
+	actual, err := json.Marshal(pushes[0])
+	assert.NoError(t, err)
+	assert.JSONEq(t, expected, string(actual))
+}
+
+// Tests that chronicle keeps log lines when Loki is unavailable and pushes
+// them on the next flush.
+func TestChronicle_Flush_retry(t *testing.T) {
+	fail := true
+	var pushed int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		pushed++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cr := loki.NewChronicle(loki.NewClient(srv.URL, ""), zap.NewNop(), loki.Options{})
+
+	assert.NoError(t, cr.Unregister(context.Background(), "foo", "1"))
+	assert.Error(t, cr.Flush(context.Background()))
+
+	fail = false
+	assert.NoError(t, cr.Flush(context.Background()))
+	assert.Equal(t, 1, pushed)
+
+	// Nothing left to push.
+	assert.NoError(t, cr.Flush(context.Background()))
+	assert.Equal(t, 1, pushed)
+}
+
+// Tests that chronicle drops log lines Loki rejects, as pushing them again
+// would fail forever.
+func TestChronicle_Flush_rejected(t *testing.T) {
+	var pushed int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed++
+		http.Error(w, "entry out of order", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	cr := loki.NewChronicle(loki.NewClient(srv.URL, ""), zap.NewNop(), loki.Options{})
+
+	assert.NoError(t, cr.Unregister(context.Background(), "foo", "1"))
+	err := cr.Flush(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "entry out of order")
+	}
+	assert.False(t, chronologist.IsRetryable(err))
+
+	// Nothing left to push.
+	assert.NoError(t, cr.Flush(context.Background()))
+	assert.Equal(t, 1, pushed)
+}
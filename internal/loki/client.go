@@ -0,0 +1,165 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const pushPath = "/loki/api/v1/push"
+
+// maxErrorBodySize limits how much of the response body is read into the
+// error message.
+const maxErrorBodySize = 512
+
+// APIError is returned when Loki responds with an unexpected status.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Message is the error message Loki responded with, e.g. that an entry
+	// is out of order.
+	Message string
+}
+
+// Error implements error.
+func (e *APIError) Error() string {
+	s := fmt.Sprintf("loki api: got response %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// Temporary reports whether the request may succeed if retried later.
+// Loki rejects entries that are out of order or too old with 400, and
+// pushing them again never succeeds.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Stream represents a set of log entries sharing the same labels.
+type Stream struct {
+	Labels  map[string]string
+	Entries []Entry
+}
+
+// Entry represents a single log line.
+type Entry struct {
+	Time time.Time
+	Line string
+}
+
+// Pusher can push log streams.
+type Pusher interface {
+	// Push pushes log streams.
+	Push(ctx context.Context, streams []Stream) error
+}
+
+// Client is a Loki HTTP API client.
+//
+// Client implements Pusher interface using Loki push API.
+type Client struct {
+	host     string
+	tenantID string
+
+	client *http.Client
+}
+
+// Push pushes log streams to Loki.
+//
+// See: https://grafana.com/docs/loki/latest/api/#post-lokiapiv1push
+func (c *Client) Push(ctx context.Context, streams []Stream) error {
+	b, err := json.Marshal(pushRequestFromStreams(streams))
+	if err != nil {
+		return errors.Wrap(err, "encode request to json")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.host+pushPath, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if c.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", c.tenantID)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		e := &APIError{StatusCode: resp.StatusCode}
+		if b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize)); err == nil {
+			e.Message = strings.TrimSpace(string(b))
+		}
+		return e
+	}
+
+	return nil
+}
+
+// NewClient returns a new Loki client. The tenantID is optional and is
+// only required for multi-tenant Loki installations.
+func NewClient(host, tenantID string) *Client {
+	return &Client{
+		host:     host,
+		tenantID: tenantID,
+		client:   http.DefaultClient,
+	}
+}
+
+type pushRequest struct {
+	Streams []pushStream `json:"streams"`
+}
+
+type pushStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func pushRequestFromStreams(streams []Stream) pushRequest {
+	req := pushRequest{Streams: make([]pushStream, 0, len(streams))}
+	for _, s := range streams {
+		ps := pushStream{
+			Stream: s.Labels,
+			Values: make([][2]string, 0, len(s.Entries)),
+		}
+		for _, e := range s.Entries {
+			ps.Values = append(ps.Values, [2]string{
+				strconv.FormatInt(e.Time.UnixNano(), 10),
+				e.Line,
+			})
+		}
+		req.Streams = append(req.Streams, ps)
+	}
+	return req
+}
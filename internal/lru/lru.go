@@ -0,0 +1,107 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package lru provides a least recently used cache. Sinks use it to remember
+// release events they have already sent without growing unbounded.
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache is a least recently used cache of a fixed size. When the cache is
+// full, adding a key evicts the least recently used one.
+//
+// Cache is safe for concurrent use.
+type Cache struct {
+	mx    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type entry struct {
+	key   string
+	value interface{}
+}
+
+// New returns a new cache holding at most size keys.
+func New(size int) *Cache {
+	if size <= 0 {
+		size = 1
+	}
+	return &Cache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value of the key, marking it as recently used.
+func (c *Cache) Get(key string) (value interface{}, ok bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+// Add sets the value of the key, evicting the least recently used key if
+// the cache is full.
+func (c *Cache) Add(key string, value interface{}) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*entry).value = value
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+	}
+}
+
+// Remove removes the key and returns its value, if any.
+func (c *Cache) Remove(key string) (value interface{}, ok bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.Remove(el)
+	delete(c.items, key)
+	return el.Value.(*entry).value, true
+}
+
+// Len returns the number of keys in the cache.
+func (c *Cache) Len() int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.ll.Len()
+}
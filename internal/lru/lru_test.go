@@ -0,0 +1,36 @@
+package lru_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hypnoglow/chronologist/internal/lru"
+)
+
+// Tests that cache evicts the least recently used key when it is full.
+func TestCache(t *testing.T) {
+	c := lru.New(2)
+
+	c.Add("a", 1)
+	c.Add("b", 2)
+
+	// Getting "a" makes "b" the least recently used.
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	c.Add("c", 3)
+	assert.Equal(t, 2, c.Len())
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+
+	v, ok = c.Remove("c")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+	assert.Equal(t, 1, c.Len())
+
+	_, ok = c.Remove("c")
+	assert.False(t, ok)
+}
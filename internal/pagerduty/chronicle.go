@@ -0,0 +1,206 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pagerduty
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/lru"
+	"github.com/hypnoglow/chronologist/internal/zaplog"
+)
+
+// RoutingKeys map release events to PagerDuty routing keys, which identify
+// services the change events are sent to.
+type RoutingKeys struct {
+	// ByRelease maps release names to routing keys.
+	ByRelease map[string]string
+
+	// ByLabel maps release labels to routing keys. Keys of the map are
+	// "<label>=<value>" pairs, e.g. "team=payments".
+	ByLabel map[string]string
+
+	// ByNamespace maps release namespaces to routing keys.
+	ByNamespace map[string]string
+
+	// Default is the routing key for release events that do not match any
+	// of the above. When empty, such release events are not sent.
+	Default string
+}
+
+// For returns the routing key for the release event. Release name mapping
+// takes precedence over label mapping, which takes precedence over namespace
+// mapping. When several labels match, the lexically first pair wins.
+func (rk RoutingKeys) For(re chronologist.ReleaseEvent) string {
+	if key, ok := rk.ByRelease[re.Name]; ok {
+		return key
+	}
+	if len(rk.ByLabel) > 0 {
+		pairs := make([]string, 0, len(re.Labels))
+		for k, v := range re.Labels {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		for _, pair := range pairs {
+			if key, ok := rk.ByLabel[pair]; ok {
+				return key
+			}
+		}
+	}
+	if key, ok := rk.ByNamespace[re.Namespace]; ok {
+		return key
+	}
+	return rk.Default
+}
+
+// Options represent PagerDuty chronicle options.
+type Options struct {
+	// Cluster is the name of the cluster, used as the source of change events.
+	Cluster string
+
+	// RoutingKeys map release events to PagerDuty services.
+	RoutingKeys RoutingKeys
+
+	// Link is an optional template of the link attached to change events,
+	// e.g. to the Grafana dashboard of the release. The template is executed
+	// with the chronologist release event as data. When the template
+	// renders an empty string, no link is attached.
+	Link *template.Template
+
+	// Since is the time release events must not be older than to be sent.
+	// Change events cannot be queried, so release events that happened
+	// before chronologist started would otherwise be sent again on every
+	// start. Zero time means all release events are sent.
+	Since time.Time
+}
+
+// sentSize is the maximum number of release revisions the chronicle
+// remembers as sent.
+const sentSize = 10000
+
+// NewChronicle returns a new PagerDuty chronicle.
+func NewChronicle(pagerduty ChangeSender, log *zap.Logger, opts Options) *Chronicle {
+	return &Chronicle{
+		pagerduty: pagerduty,
+		log:       log,
+		opts:      opts,
+		sent:      lru.New(sentSize),
+	}
+}
+
+// A Chronicle sends release events to PagerDuty as change events, so they
+// are shown as recent changes on incidents.
+//
+// Change events cannot be updated or deleted, so a new change event is sent
+// each time the release event changes, and unregistering is a no-op.
+type Chronicle struct {
+	pagerduty ChangeSender
+	log       *zap.Logger
+	opts      Options
+
+	// sent holds the last release event sent for recent release revisions,
+	// so resyncs do not produce duplicate change events.
+	sent *lru.Cache
+}
+
+// Register adds the release event to the chronicle, sending a change event
+// unless the same release event has already been sent.
+func (c *Chronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	log := zaplog.Grasp(ctx, c.log)
+
+	if re.Time.Before(c.opts.Since) {
+		log.Debug("Release event is older than chronologist, skip")
+		return nil
+	}
+
+	key := re.Name + "/" + re.Revision
+
+	if prev, ok := c.sent.Get(key); ok && len(re.Differences(prev.(chronologist.ReleaseEvent))) == 0 {
+		log.Debug("Release event has already been sent to PagerDuty, skip")
+		return nil
+	}
+
+	routingKey := c.opts.RoutingKeys.For(re)
+	if routingKey == "" {
+		log.Debug("No PagerDuty routing key for the release event, skip")
+		return nil
+	}
+
+	ev, err := c.changeEvent(routingKey, re)
+	if err != nil {
+		return err
+	}
+
+	log.Debug("Sending PagerDuty change event for the release event")
+	if err = c.pagerduty.SendChangeEvent(ctx, ev); err != nil {
+		return errors.Wrap(err, "send change event to pagerduty")
+	}
+
+	c.sent.Add(key, re)
+	return nil
+}
+
+// Unregister removes the release event from the chronicle. PagerDuty change
+// events cannot be deleted, so nothing is sent.
+func (c *Chronicle) Unregister(ctx context.Context, name, revision string) error {
+	c.sent.Remove(name + "/" + revision)
+
+	zaplog.Grasp(ctx, c.log).Debug("PagerDuty does not support deleting change events, skip")
+	return nil
+}
+
+func (c *Chronicle) changeEvent(routingKey string, re chronologist.ReleaseEvent) (ChangeEvent, error) {
+	source := c.opts.Cluster
+	if source == "" {
+		source = "chronologist"
+	}
+
+	details := make(map[string]string)
+	for _, tag := range re.Tags() {
+		details[tag.Key] = tag.Value
+	}
+
+	ev := ChangeEvent{
+		RoutingKey: routingKey,
+		Payload: Payload{
+			Summary:       fmt.Sprintf("%s (revision %s, %s) in %s", re.Summary(), re.Revision, re.Status, re.Namespace),
+			Timestamp:     re.Time.UTC().Format(time.RFC3339),
+			Source:        source,
+			CustomDetails: details,
+		},
+	}
+
+	if c.opts.Link != nil {
+		var buf bytes.Buffer
+		if err := c.opts.Link.Execute(&buf, re); err != nil {
+			return ChangeEvent{}, errors.Wrap(err, "execute link template")
+		}
+		if href := buf.String(); href != "" {
+			ev.Links = []Link{{Href: href, Text: "Grafana dashboard"}}
+		}
+	}
+
+	return ev, nil
+}
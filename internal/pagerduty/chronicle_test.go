@@ -0,0 +1,194 @@
+package pagerduty_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/pagerduty"
+)
+
+func TestRoutingKeys_For(t *testing.T) {
+	rk := pagerduty.RoutingKeys{
+		ByRelease: map[string]string{"api": "release-key"},
+		ByLabel: map[string]string{
+			"team=payments": "payments-key",
+			"tier=backend":  "backend-key",
+		},
+		ByNamespace: map[string]string{"infra": "namespace-key"},
+		Default:     "default-key",
+	}
+
+	testCases := []struct {
+		name     string
+		re       chronologist.ReleaseEvent
+		expected string
+	}{
+		{
+			name:     "release name wins over everything",
+			re:       chronologist.ReleaseEvent{Name: "api", Namespace: "infra", Labels: map[string]string{"team": "payments"}},
+			expected: "release-key",
+		},
+		{
+			name:     "label wins over namespace",
+			re:       chronologist.ReleaseEvent{Name: "web", Namespace: "infra", Labels: map[string]string{"team": "payments"}},
+			expected: "payments-key",
+		},
+		{
+			name:     "lexically first label pair wins",
+			re:       chronologist.ReleaseEvent{Name: "web", Labels: map[string]string{"tier": "backend", "team": "payments"}},
+			expected: "payments-key",
+		},
+		{
+			name:     "label value must match",
+			re:       chronologist.ReleaseEvent{Name: "web", Namespace: "infra", Labels: map[string]string{"team": "search"}},
+			expected: "namespace-key",
+		},
+		{
+			name:     "default",
+			re:       chronologist.ReleaseEvent{Name: "web", Namespace: "default"},
+			expected: "default-key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, rk.For(tc.re))
+		})
+	}
+
+	assert.Empty(t, pagerduty.RoutingKeys{}.For(chronologist.ReleaseEvent{Name: "web"}))
+}
+
+// Tests that chronicle sends a change event once per release event change,
+// and skips release events without a routing key.
+func TestChronicle_Register(t *testing.T) {
+	var events []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/change/enqueue", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		events = append(events, body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	cr := pagerduty.NewChronicle(pagerduty.NewClient(srv.URL), zap.NewNop(), pagerduty.Options{
+		Cluster: "dev",
+		RoutingKeys: pagerduty.RoutingKeys{
+			ByLabel: map[string]string{"team=payments": "payments-key"},
+		},
+		Link: template.Must(template.New("link").Parse("https://grafana.example.com/d/abc?var-release={{ .Name }}")),
+	})
+
+	re := chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+		Labels:    map[string]string{"team": "payments"},
+	}
+	ctx := context.Background()
+
+	require.NoError(t, cr.Register(ctx, re))
+	require.NoError(t, cr.Register(ctx, re))
+	require.Len(t, events, 1)
+
+	expected := `{"routing_key":"payments-key","payload":{` +
+		`"summary":"Rollout release foo (revision 1, DEPLOYED) in default",` +
+		`"timestamp":"2019-01-02T15:04:05Z","source":"dev","custom_details":{` +
+		`"event":"release","heritage":"chronologist","release_type":"rollout",` +
+		`"release_status":"DEPLOYED","release_name":"foo","release_revision":"1",` +
+		`"release_namespace":"default"}},` +
+		`"links":[{"href":"https://grafana.example.com/d/abc?var-release=foo","text":"Grafana dashboard"}]}`
+	actual, err := json.Marshal(events[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, expected, string(actual))
+
+	// Changed status is a new change event.
+	re.Status = "SUPERSEDED"
+	require.NoError(t, cr.Register(ctx, re))
+	assert.Len(t, events, 2)
+
+	// After unregistering, the same release event is sent again.
+	require.NoError(t, cr.Unregister(ctx, "foo", "1"))
+	require.NoError(t, cr.Register(ctx, re))
+	assert.Len(t, events, 3)
+
+	// No routing key, nothing is sent.
+	re.Labels = map[string]string{"team": "search"}
+	re.Revision = "2"
+	require.NoError(t, cr.Register(ctx, re))
+	assert.Len(t, events, 3)
+}
+
+// Tests that a failed change event is not remembered as sent, so it is
+// retried on the next resync.
+func TestChronicle_Register_error(t *testing.T) {
+	fail := true
+	var sent int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		sent++
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	cr := pagerduty.NewChronicle(pagerduty.NewClient(srv.URL), zap.NewNop(), pagerduty.Options{
+		RoutingKeys: pagerduty.RoutingKeys{Default: "key"},
+	})
+	re := chronologist.ReleaseEvent{Name: "foo", Revision: "1", Status: "DEPLOYED"}
+
+	assert.Error(t, cr.Register(context.Background(), re))
+
+	fail = false
+	assert.NoError(t, cr.Register(context.Background(), re))
+	assert.NoError(t, cr.Register(context.Background(), re))
+	assert.Equal(t, 1, sent)
+}
+
+// Tests that chronicle does not send release events older than Since, and
+// attaches no link when the link template renders an empty string.
+func TestChronicle_Register_since(t *testing.T) {
+	var events []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		events = append(events, body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	since := time.Date(2019, 01, 02, 15, 0, 0, 0, time.UTC)
+	cr := pagerduty.NewChronicle(pagerduty.NewClient(srv.URL), zap.NewNop(), pagerduty.Options{
+		RoutingKeys: pagerduty.RoutingKeys{Default: "key"},
+		Link:        template.Must(template.New("link").Parse("")),
+		Since:       since,
+	})
+	ctx := context.Background()
+
+	re := chronologist.ReleaseEvent{Time: since.Add(-time.Second), Name: "foo", Revision: "1", Status: "DEPLOYED"}
+	require.NoError(t, cr.Register(ctx, re))
+	assert.Empty(t, events)
+
+	re.Time = since
+	require.NoError(t, cr.Register(ctx, re))
+	require.Len(t, events, 1)
+	assert.NotContains(t, events[0], "links")
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pagerduty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultHost is the address of PagerDuty Events API.
+const DefaultHost = "https://events.pagerduty.com"
+
+// ChangeEvent represents PagerDuty change event.
+type ChangeEvent struct {
+	RoutingKey string  `json:"routing_key"`
+	Payload    Payload `json:"payload"`
+	Links      []Link  `json:"links,omitempty"`
+}
+
+// Payload represents the payload of the change event.
+type Payload struct {
+	Summary       string            `json:"summary"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Source        string            `json:"source,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// Link represents a link attached to the change event.
+type Link struct {
+	Href string `json:"href"`
+	Text string `json:"text,omitempty"`
+}
+
+// ChangeSender can send change events.
+type ChangeSender interface {
+	// SendChangeEvent sends the change event.
+	SendChangeEvent(ctx context.Context, event ChangeEvent) error
+}
+
+// Client is a PagerDuty Events API client.
+//
+// Client implements ChangeSender interface.
+type Client struct {
+	host string
+
+	client *http.Client
+}
+
+// SendChangeEvent sends the change event to PagerDuty.
+//
+// See: https://developer.pagerduty.com/docs/events-api-v2/send-change-events/
+func (c *Client) SendChangeEvent(ctx context.Context, event ChangeEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "encode request to json")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.host+"/v2/change/enqueue", bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return errors.Errorf("got response %s", resp.Status)
+	}
+
+	return nil
+}
+
+// NewClient returns a new PagerDuty client. If host is empty, DefaultHost
+// is used.
+func NewClient(host string) *Client {
+	if host == "" {
+		host = DefaultHost
+	}
+	return &Client{
+		host:   host,
+		client: http.DefaultClient,
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pushapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/lru"
+	"github.com/hypnoglow/chronologist/pkg/push"
+)
+
+const (
+	// maxBodySize limits the size of a request body.
+	maxBodySize = 1 << 20
+
+	// seenSize is the maximum number of release revisions the handler
+	// remembers as registered.
+	seenSize = 10000
+)
+
+// Options are options of the handler.
+type Options struct {
+	// Token is required in "Authorization: Bearer <token>" header
+	// of each request.
+	Token string
+}
+
+// NewHandler returns a new handler of push API that feeds release events
+// into the chronicle.
+func NewHandler(chronicle chronologist.Chronicle, log *zap.Logger, opts Options) *Handler {
+	return &Handler{
+		chronicle: chronicle,
+		log:       log,
+		token:     opts.Token,
+		locks:     make(map[string]*keyLock),
+		seen:      lru.New(seenSize),
+	}
+}
+
+// Handler serves push API.
+type Handler struct {
+	chronicle chronologist.Chronicle
+	log       *zap.Logger
+	token     string
+
+	// mu guards locks, which serialize handling of release events of the
+	// same release revision, so that concurrent pushes of it do not race
+	// in the chronicle.
+	mu    sync.Mutex
+	locks map[string]*keyLock
+
+	// seen holds recent release events registered successfully, by key.
+	// It makes pushing the same release event again a no-op, and tells the
+	// namespace a release revision is registered in.
+	seen *lru.Cache
+}
+
+// keyLock is a lock of a release revision, shared by the requests that
+// hold or wait for it.
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks the release revision and returns the function to unlock it.
+func (h *Handler) lock(key string) (unlock func()) {
+	h.mu.Lock()
+	l, ok := h.locks[key]
+	if !ok {
+		l = &keyLock{}
+		h.locks[key] = l
+	}
+	l.refs++
+	h.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		h.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(h.locks, key)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		h.respondError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	switch {
+	case r.URL.Path == push.EventsPath:
+		if r.Method != http.MethodPost {
+			h.respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.register(w, r)
+	case strings.HasPrefix(r.URL.Path, push.EventsPath+"/"):
+		if r.Method != http.MethodDelete {
+			h.respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.unregister(w, r)
+	default:
+		h.respondError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, fmt.Sprintf("read request body: %s", err))
+		return
+	}
+
+	var pre push.ReleaseEvent
+	if err := json.Unmarshal(b, &pre); err != nil {
+		h.respondError(w, http.StatusBadRequest, fmt.Sprintf("decode request body from json: %s", err))
+		return
+	}
+
+	re, err := releaseEventFromPush(pre)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log := h.log.With(
+		zap.String("release_namespace", re.Namespace),
+		zap.String("release_name", re.Name),
+		zap.String("release_revision", re.Revision),
+	)
+
+	key := eventKey(re.Name, re.Revision)
+	defer h.lock(key)()
+
+	if v, ok := h.seen.Get(key); ok {
+		seen := v.(chronologist.ReleaseEvent)
+		if seen.Namespace != re.Namespace {
+			h.respondError(w, http.StatusConflict, conflictMessage(seen))
+			return
+		}
+		if reflect.DeepEqual(seen, re) {
+			log.Debug("Pushed release event is unchanged")
+			h.respond(w, push.StatusUnchanged)
+			return
+		}
+	}
+
+	if err := h.chronicle.Register(r.Context(), re); err != nil {
+		log.Error("Failed to register pushed release event", zap.Error(err))
+		h.respondError(w, statusCodeFromError(err), fmt.Sprintf("register release event: %s", err))
+		return
+	}
+	h.seen.Add(key, re)
+
+	log.Info("Registered pushed release event")
+	h.respond(w, push.StatusRegistered)
+}
+
+func (h *Handler) unregister(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, push.EventsPath+"/"), "/")
+	if len(parts) != 3 {
+		h.respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	namespace, name, revision := parts[0], parts[1], parts[2]
+	if err := validateKey(namespace, name, revision); err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log := h.log.With(
+		zap.String("release_namespace", namespace),
+		zap.String("release_name", name),
+		zap.String("release_revision", revision),
+	)
+
+	key := eventKey(name, revision)
+	defer h.lock(key)()
+
+	// Chronicles know release revisions by names, so unregistering would
+	// delete the release revision registered in another namespace.
+	if v, ok := h.seen.Get(key); ok && v.(chronologist.ReleaseEvent).Namespace != namespace {
+		h.respondError(w, http.StatusConflict, conflictMessage(v.(chronologist.ReleaseEvent)))
+		return
+	}
+
+	if err := h.chronicle.Unregister(r.Context(), name, revision); err != nil {
+		log.Error("Failed to unregister pushed release event", zap.Error(err))
+		h.respondError(w, statusCodeFromError(err), fmt.Sprintf("unregister release event: %s", err))
+		return
+	}
+	h.seen.Remove(key)
+
+	log.Info("Unregistered pushed release event")
+	h.respond(w, push.StatusUnregistered)
+}
+
+func (h *Handler) respond(w http.ResponseWriter, status string) {
+	h.write(w, http.StatusOK, push.Response{Status: status})
+}
+
+func (h *Handler) respondError(w http.ResponseWriter, code int, message string) {
+	h.write(w, code, push.Response{Message: message})
+}
+
+func (h *Handler) write(w http.ResponseWriter, code int, resp push.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.log.Sugar().Errorf("Failed to write push API response: %s", err)
+	}
+}
+
+// releaseEventFromPush validates the pushed release event and converts it
+// to the domain release event.
+func releaseEventFromPush(pre push.ReleaseEvent) (chronologist.ReleaseEvent, error) {
+	if err := validateKey(pre.Namespace, pre.Name, pre.Revision); err != nil {
+		return chronologist.ReleaseEvent{}, err
+	}
+	if pre.Status == "" {
+		return chronologist.ReleaseEvent{}, errors.New("status is required")
+	}
+	if pre.Time.IsZero() {
+		return chronologist.ReleaseEvent{}, errors.New("time is required")
+	}
+
+	var typ chronologist.ReleaseType
+	switch pre.Type {
+	case push.TypeRollout:
+		typ = chronologist.ReleaseTypeRollout
+	case push.TypeRollback:
+		typ = chronologist.ReleaseTypeRollback
+	default:
+		return chronologist.ReleaseEvent{}, errors.Errorf("type must be either %q or %q, got %q", push.TypeRollout, push.TypeRollback, pre.Type)
+	}
+
+	re := chronologist.ReleaseEvent{
+		// Informers get time of a release with seconds precision, so do
+		// the same to keep annotations of the release consistent.
+		Time:      pre.Time.UTC().Truncate(time.Second),
+		Type:      typ,
+		Status:    pre.Status,
+		Name:      pre.Name,
+		Revision:  pre.Revision,
+		Namespace: pre.Namespace,
+		Labels:    pre.Labels,
+	}
+	if pre.Chart != nil {
+		re.Chart = chronologist.Chart{
+			Name:        pre.Chart.Name,
+			Version:     pre.Chart.Version,
+			AppVersion:  pre.Chart.AppVersion,
+			Annotations: pre.Chart.Annotations,
+		}
+	}
+	return re, nil
+}
+
+func validateKey(namespace, name, revision string) error {
+	if namespace == "" {
+		return errors.New("namespace is required")
+	}
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if n, err := strconv.Atoi(revision); err != nil || n <= 0 {
+		return errors.Errorf("revision must be a positive integer, got %q", revision)
+	}
+	return nil
+}
+
+// eventKey returns the key of the release revision. Chronicles know release
+// revisions by names, as Helm release names are unique in the cluster, so
+// the namespace is not a part of the key.
+func eventKey(name, revision string) string {
+	return name + "/" + revision
+}
+
+// conflictMessage returns the message of the response to a request about
+// the release revision of the release event in another namespace.
+func conflictMessage(re chronologist.ReleaseEvent) string {
+	return fmt.Sprintf("release %s revision %s is registered in namespace %s", re.Name, re.Revision, re.Namespace)
+}
+
+// statusCodeFromError returns 503 if retrying the request may help,
+// so that clients know they can retry it, and 500 otherwise.
+func statusCodeFromError(err error) int {
+	if chronologist.IsRetryable(err) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+var _ http.Handler = (*Handler)(nil)
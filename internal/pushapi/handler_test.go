@@ -0,0 +1,290 @@
+package pushapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/hypnoglow/chronologist/internal/chronologist"
+	"github.com/hypnoglow/chronologist/internal/pushapi"
+	"github.com/hypnoglow/chronologist/pkg/push"
+)
+
+type fakeChronicle struct {
+	registered   []chronologist.ReleaseEvent
+	unregistered []string
+	err          error
+}
+
+func (c *fakeChronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.registered = append(c.registered, re)
+	return nil
+}
+
+func (c *fakeChronicle) Unregister(ctx context.Context, name, revision string) error {
+	c.unregistered = append(c.unregistered, name+"/"+revision)
+	return nil
+}
+
+func newServer(chronicle chronologist.Chronicle) *httptest.Server {
+	h := pushapi.NewHandler(chronicle, zap.NewNop(), pushapi.Options{Token: "secret"})
+	return httptest.NewServer(h)
+}
+
+// Tests that pushed release events are registered once per revision,
+// and that deletions are unregistered.
+func TestHandler(t *testing.T) {
+	chronicle := &fakeChronicle{}
+	srv := newServer(chronicle)
+	defer srv.Close()
+	client := push.NewClient(srv.URL, "secret")
+	ctx := context.Background()
+
+	re := push.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 999, time.FixedZone("MSK", 3*60*60)),
+		Type:      push.TypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "2",
+		Namespace: "default",
+		Chart:     &push.Chart{Name: "foo", Version: "1.0.0"},
+	}
+
+	status, err := client.Register(ctx, re)
+	require.NoError(t, err)
+	assert.Equal(t, push.StatusRegistered, status)
+
+	status, err = client.Register(ctx, re)
+	require.NoError(t, err)
+	assert.Equal(t, push.StatusUnchanged, status)
+
+	re.Status = "SUPERSEDED"
+	status, err = client.Register(ctx, re)
+	require.NoError(t, err)
+	assert.Equal(t, push.StatusRegistered, status)
+
+	require.Len(t, chronicle.registered, 2)
+	assert.Equal(t, chronologist.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 12, 4, 5, 0, time.UTC),
+		Type:      chronologist.ReleaseTypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "2",
+		Namespace: "default",
+		Chart:     chronologist.Chart{Name: "foo", Version: "1.0.0"},
+	}, chronicle.registered[0])
+	assert.Equal(t, "SUPERSEDED", chronicle.registered[1].Status)
+
+	status, err = client.Unregister(ctx, "default", "foo", "2")
+	require.NoError(t, err)
+	assert.Equal(t, push.StatusUnregistered, status)
+	assert.Equal(t, []string{"foo/2"}, chronicle.unregistered)
+
+	// Once deleted, the release event is registered again if pushed.
+	status, err = client.Register(ctx, re)
+	require.NoError(t, err)
+	assert.Equal(t, push.StatusRegistered, status)
+}
+
+// Tests that invalid requests are rejected without reaching the chronicle.
+func TestHandler_invalid(t *testing.T) {
+	valid := push.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      push.TypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	testCases := map[string]struct {
+		token  string
+		modify func(re *push.ReleaseEvent)
+		code   int
+	}{
+		"invalid token": {
+			token:  "wrong",
+			modify: func(re *push.ReleaseEvent) {},
+			code:   http.StatusUnauthorized,
+		},
+		"no name": {
+			token:  "secret",
+			modify: func(re *push.ReleaseEvent) { re.Name = "" },
+			code:   http.StatusBadRequest,
+		},
+		"invalid revision": {
+			token:  "secret",
+			modify: func(re *push.ReleaseEvent) { re.Revision = "0" },
+			code:   http.StatusBadRequest,
+		},
+		"no time": {
+			token:  "secret",
+			modify: func(re *push.ReleaseEvent) { re.Time = time.Time{} },
+			code:   http.StatusBadRequest,
+		},
+		"invalid type": {
+			token:  "secret",
+			modify: func(re *push.ReleaseEvent) { re.Type = "upgrade" },
+			code:   http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			chronicle := &fakeChronicle{}
+			srv := newServer(chronicle)
+			defer srv.Close()
+			client := push.NewClient(srv.URL, tc.token)
+
+			re := valid
+			tc.modify(&re)
+
+			_, err := client.Register(context.Background(), re)
+			require.Error(t, err)
+			require.IsType(t, &push.Error{}, err)
+			assert.Equal(t, tc.code, err.(*push.Error).StatusCode)
+			assert.False(t, err.(*push.Error).Temporary())
+			assert.Empty(t, chronicle.registered)
+		})
+	}
+}
+
+type temporaryError bool
+
+func (e temporaryError) Error() string   { return "temporary error" }
+func (e temporaryError) Temporary() bool { return bool(e) }
+
+// Tests that failures of the chronicle tell clients whether they can retry,
+// looking into errors aggregated by chronologist.MultiChronicle.
+func TestHandler_chronicleError(t *testing.T) {
+	testCases := map[string]struct {
+		err  error
+		code int
+	}{
+		"temporary": {
+			err:  chronologist.MultiChronicle{&fakeChronicle{err: temporaryError(true)}, &fakeChronicle{err: temporaryError(false)}}.Register(context.Background(), chronologist.ReleaseEvent{}),
+			code: http.StatusServiceUnavailable,
+		},
+		"permanent": {
+			err:  chronologist.MultiChronicle{&fakeChronicle{err: temporaryError(false)}, &fakeChronicle{}}.Register(context.Background(), chronologist.ReleaseEvent{}),
+			code: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			srv := newServer(&fakeChronicle{err: tc.err})
+			defer srv.Close()
+			client := push.NewClient(srv.URL, "secret")
+
+			_, err := client.Register(context.Background(), push.ReleaseEvent{
+				Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+				Type:      push.TypeRollout,
+				Status:    "DEPLOYED",
+				Name:      "foo",
+				Revision:  "1",
+				Namespace: "default",
+			})
+			require.IsType(t, &push.Error{}, err)
+			assert.Equal(t, tc.code, err.(*push.Error).StatusCode)
+			assert.Equal(t, tc.code == http.StatusServiceUnavailable, err.(*push.Error).Temporary())
+		})
+	}
+}
+
+// Tests that a release revision registered in one namespace is neither
+// overwritten nor unregistered by requests about another namespace.
+func TestHandler_namespaceConflict(t *testing.T) {
+	chronicle := &fakeChronicle{}
+	srv := newServer(chronicle)
+	defer srv.Close()
+	client := push.NewClient(srv.URL, "secret")
+	ctx := context.Background()
+
+	re := push.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      push.TypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "foo",
+		Revision:  "1",
+		Namespace: "default",
+	}
+	_, err := client.Register(ctx, re)
+	require.NoError(t, err)
+
+	other := re
+	other.Namespace = "other"
+	_, err = client.Register(ctx, other)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusConflict, err.(*push.Error).StatusCode)
+	assert.Equal(t, "release foo revision 1 is registered in namespace default", err.(*push.Error).Message)
+
+	_, err = client.Unregister(ctx, "other", "foo", "1")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusConflict, err.(*push.Error).StatusCode)
+
+	assert.Len(t, chronicle.registered, 1)
+	assert.Empty(t, chronicle.unregistered)
+}
+
+// blockingChronicle blocks registering release events named "slow" until
+// release is closed, and closes entered when it blocks.
+type blockingChronicle struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingChronicle) Register(ctx context.Context, re chronologist.ReleaseEvent) error {
+	if re.Name == "slow" {
+		close(c.entered)
+		<-c.release
+	}
+	return nil
+}
+
+func (c *blockingChronicle) Unregister(ctx context.Context, name, revision string) error {
+	return nil
+}
+
+// Tests that a slow release event does not hold up release events of other
+// releases.
+func TestHandler_concurrent(t *testing.T) {
+	chronicle := &blockingChronicle{entered: make(chan struct{}), release: make(chan struct{})}
+	srv := newServer(chronicle)
+	defer srv.Close()
+	client := push.NewClient(srv.URL, "secret")
+	ctx := context.Background()
+
+	re := push.ReleaseEvent{
+		Time:      time.Date(2019, 01, 02, 15, 4, 5, 0, time.UTC),
+		Type:      push.TypeRollout,
+		Status:    "DEPLOYED",
+		Name:      "slow",
+		Revision:  "1",
+		Namespace: "default",
+	}
+
+	slow := make(chan error, 1)
+	go func(re push.ReleaseEvent) {
+		_, err := client.Register(ctx, re)
+		slow <- err
+	}(re)
+	<-chronicle.entered
+
+	re.Name = "fast"
+	status, err := client.Register(ctx, re)
+	require.NoError(t, err)
+	assert.Equal(t, push.StatusRegistered, status)
+
+	close(chronicle.release)
+	assert.NoError(t, <-slow)
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2018 The Chronologist Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Release types.
+const (
+	TypeRollout  = "rollout"
+	TypeRollback = "rollback"
+)
+
+// ReleaseEvent is a helm release event.
+type ReleaseEvent struct {
+	// Time the release revision was deployed at.
+	Time time.Time `json:"time"`
+
+	// Type is either TypeRollout or TypeRollback.
+	Type string `json:"type"`
+
+	// Status of the release revision, e.g. "DEPLOYED".
+	Status string `json:"status"`
+
+	Name      string `json:"name"`
+	Revision  string `json:"revision"`
+	Namespace string `json:"namespace"`
+
+	// Chart the release was deployed from, if known.
+	Chart *Chart `json:"chart,omitempty"`
+
+	// Labels of the release, used by routing rules.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Chart describes a chart.
+type Chart struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	AppVersion  string            `json:"appVersion,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Response is a response of push API.
+type Response struct {
+	// Status tells what was done with the release event: "registered",
+	// "unchanged" or "unregistered".
+	Status string `json:"status,omitempty"`
+
+	// Message describes the error, if any.
+	Message string `json:"message,omitempty"`
+}
+
+// Response statuses.
+const (
+	StatusRegistered   = "registered"
+	StatusUnchanged    = "unchanged"
+	StatusUnregistered = "unregistered"
+)
+
+// Error is returned when push API responds with an error.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("chronologist push api: got response %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried later.
+// Chronologist responds with 503 when it is so, and with 500 when retrying
+// will not help, e.g. when Grafana rejects its credentials.
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// EventsPath is the path of release events in push API.
+const EventsPath = "/api/v1/events"
+
+// NewClient returns a new client of push API of Chronologist at addr,
+// authorized with the token.
+func NewClient(addr, token string) *Client {
+	return &Client{
+		addr:  addr,
+		token: token,
+		client: &http.Client{
+			Timeout: time.Minute,
+		},
+	}
+}
+
+// Client is a client of push API.
+type Client struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// Register reports the release event. Reporting the same release event
+// again is a no-op, so it is safe to retry. It returns the status of the
+// release event.
+func (c *Client) Register(ctx context.Context, re ReleaseEvent) (string, error) {
+	b, err := json.Marshal(re)
+	if err != nil {
+		return "", errors.Wrap(err, "encode request to json")
+	}
+
+	return c.do(ctx, http.MethodPost, c.addr+EventsPath, bytes.NewReader(b))
+}
+
+// Unregister reports that the release revision was deleted.
+func (c *Client) Unregister(ctx context.Context, namespace, name, revision string) (string, error) {
+	u := fmt.Sprintf("%s%s/%s/%s/%s", c.addr, EventsPath,
+		url.PathEscape(namespace), url.PathEscape(name), url.PathEscape(revision),
+	)
+	return c.do(ctx, http.MethodDelete, u, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, u string, body io.Reader) (string, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return "", errors.Wrap(err, "create request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "read response body")
+	}
+
+	var r Response
+	if err := json.Unmarshal(b, &r); err != nil && resp.StatusCode == http.StatusOK {
+		return "", errors.Wrap(err, "decode response body from json")
+	}
+	if resp.StatusCode != http.StatusOK {
+		if r.Message == "" {
+			r.Message = string(b)
+		}
+		return "", &Error{StatusCode: resp.StatusCode, Message: r.Message}
+	}
+
+	return r.Status, nil
+}